@@ -0,0 +1,325 @@
+// Copyright 2016 The Govisor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package govisor
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// ManifestError is a problem found in a manifest by CheckManifests.  Line
+// and Column are only set for syntax errors, and are 1-based.
+type ManifestError struct {
+	File   string
+	Line   int
+	Column int
+	Err    error
+}
+
+func (e *ManifestError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s:%d:%d: %v", e.File, e.Line, e.Column,
+			e.Err)
+	}
+	return fmt.Sprintf("%s: %v", e.File, e.Err)
+}
+
+func (e *ManifestError) Unwrap() error {
+	return e.Err
+}
+
+// checked is a manifest that parsed successfully.
+type checked struct {
+	file string
+	m    ProcessManifest
+}
+
+func (c *checked) matches(check string) bool {
+	if serviceMatches(check, c.m.Name) {
+		return true
+	}
+	for _, p := range c.m.Provides {
+		if serviceMatches(check, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckManifests parses every manifest in the directory, and reports the
+// problems it finds, without starting anything.  Besides errors in the
+// manifests themselves, it checks that commands and directories exist,
+// that names are unique, that every dependency is provided by some service,
+// that there are no dependency cycles, and that no service conflicts with
+// something it depends upon.  Templates are checked for each instance that
+// they list.  The errors are all *ManifestError, and are
+// returned in file order.
+func CheckManifests(dir string) []error {
+	infos, e := ioutil.ReadDir(dir)
+	if e != nil {
+		return []error{e}
+	}
+	var errs []error
+	var all []*checked
+	report := func(file string, format string, v ...interface{}) {
+		errs = append(errs, &ManifestError{
+			File: file,
+			Err:  fmt.Errorf(format, v...),
+		})
+	}
+
+	lint := func(fname string, m ProcessManifest) {
+		if m.Name == "" {
+			report(fname, "missing name")
+		}
+		if m.Directory != "" && !strings.Contains(m.Directory, "${") {
+			if st, e := os.Stat(m.Directory); e != nil {
+				report(fname, "bad directory: %v", e)
+			} else if !st.IsDir() {
+				report(fname, "bad directory: %s is not a directory",
+					m.Directory)
+			}
+		}
+		if len(m.Command) == 0 || m.Command[0] == "" {
+			report(fname, "missing command")
+		} else if strings.Contains(m.Command[0]+m.Directory, "${") {
+			// Depends upon the environment when started.
+		} else if e := checkExecutable(m.Command[0], m.Directory); e != nil {
+			report(fname, "bad command: %v", e)
+		}
+		if m.User != "" || m.Group != "" || len(m.Groups) != 0 {
+			_, e := lookupCredential(m.User, m.Group, m.Groups)
+			if e != nil {
+				report(fname, "bad credentials: %v", e)
+			}
+		}
+		for _, ef := range m.EnvFiles {
+			if strings.HasPrefix(ef, "-") {
+				continue
+			}
+			if _, e := os.Stat(ef); e != nil {
+				report(fname, "bad environment file: %v", e)
+			}
+		}
+		all = append(all, &checked{file: fname, m: m})
+	}
+
+	for _, fi := range infos {
+		if fi.IsDir() || strings.HasPrefix(fi.Name(), ".") {
+			continue
+		}
+		fname := filepath.Join(dir, fi.Name())
+		b, e := ioutil.ReadFile(fname)
+		if e != nil {
+			errs = append(errs, &ManifestError{File: fname, Err: e})
+			continue
+		}
+		m, e := DecodeManifestFormat(bytes.NewReader(b),
+			ManifestFormat(fname))
+		if e != nil {
+			me := &ManifestError{File: fname, Err: e}
+			var se *json.SyntaxError
+			var te *json.UnmarshalTypeError
+			var pe toml.ParseError
+			if errors.As(e, &se) {
+				me.Line, me.Column = position(b, se.Offset)
+			} else if errors.As(e, &te) {
+				me.Line, me.Column = position(b, te.Offset)
+			} else if errors.As(e, &pe) {
+				me.Line, me.Column = position(b,
+					int64(pe.Position.Start)+1)
+			}
+			errs = append(errs, me)
+			continue
+		}
+		tmpl := templateName(fname, m)
+		if tmpl == "" {
+			if len(m.Instances) != 0 {
+				report(fname, "instances given, but not a template")
+			}
+			lint(fname, m)
+			continue
+		}
+		if e := checkTemplate(tmpl, m); e != nil {
+			report(fname, "%v", e)
+			continue
+		}
+		for _, inst := range m.Instances {
+			lint(fname, instanceManifest(tmpl, m, inst))
+		}
+	}
+
+	names := make(map[string]*checked)
+	for _, c := range all {
+		if prev, ok := names[c.m.Name]; ok && c.m.Name != "" {
+			report(c.file, "%v: %s (also in %s)", ErrNameExists,
+				c.m.Name, prev.file)
+			continue
+		}
+		names[c.m.Name] = c
+	}
+
+	// Work out who satisfies whose dependencies.
+	parents := make(map[*checked][]*checked)
+	for _, c := range all {
+		for _, d := range c.m.Depends {
+			found := false
+			for _, p := range all {
+				if p != c && p.matches(d) {
+					parents[c] = append(parents[c], p)
+					found = true
+				}
+			}
+			if !found {
+				report(c.file, "nothing provides dependency %s", d)
+			}
+		}
+	}
+
+	for _, c := range all {
+		if cycle := findCycle(c, parents); cycle != nil {
+			report(c.file, "dependency cycle: %s",
+				strings.Join(cycle, " -> "))
+		}
+	}
+
+	// Anything we need, directly or indirectly, must not conflict with us.
+	for _, c := range all {
+		seen := make(map[*checked]bool)
+		var visit func(*checked)
+		visit = func(n *checked) {
+			for _, p := range parents[n] {
+				if seen[p] || p == c {
+					continue
+				}
+				seen[p] = true
+				visit(p)
+			}
+		}
+		visit(c)
+		deps := make([]*checked, 0, len(seen))
+		for p := range seen {
+			deps = append(deps, p)
+		}
+		sort.Slice(deps, func(i, j int) bool {
+			return deps[i].m.Name < deps[j].m.Name
+		})
+		for _, p := range deps {
+			if conflicts(c, p) || conflicts(p, c) {
+				report(c.file, "conflicts with dependency %s",
+					p.m.Name)
+			}
+		}
+	}
+
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].(*ManifestError).File < errs[j].(*ManifestError).File
+	})
+	return errs
+}
+
+func conflicts(a, b *checked) bool {
+	for _, x := range a.m.Conflicts {
+		if b.matches(x) {
+			return true
+		}
+	}
+	return false
+}
+
+// findCycle returns the names along a dependency cycle that starts and
+// ends with c, or nil if there is none.
+func findCycle(c *checked, parents map[*checked][]*checked) []string {
+	seen := make(map[*checked]bool)
+	var path []*checked
+	var walk func(n *checked) bool
+	walk = func(n *checked) bool {
+		path = append(path, n)
+		for _, p := range parents[n] {
+			if p == c {
+				path = append(path, p)
+				return true
+			}
+			if !seen[p] {
+				seen[p] = true
+				if walk(p) {
+					return true
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		return false
+	}
+	if !walk(c) {
+		return nil
+	}
+	names := make([]string, 0, len(path))
+	for _, n := range path {
+		names = append(names, n.m.Name)
+	}
+	return names
+}
+
+// checkExecutable checks that the command can be run.  Relative paths are
+// resolved against the working directory of the process, and bare names
+// are looked up in $PATH.
+func checkExecutable(cmd string, dir string) error {
+	if !strings.Contains(cmd, string(filepath.Separator)) {
+		_, e := exec.LookPath(cmd)
+		return e
+	}
+	if !filepath.IsAbs(cmd) && dir != "" {
+		cmd = filepath.Join(dir, cmd)
+	}
+	st, e := os.Stat(cmd)
+	if e != nil {
+		return e
+	}
+	if st.IsDir() || st.Mode()&0111 == 0 {
+		return fmt.Errorf("%s is not executable", cmd)
+	}
+	return nil
+}
+
+// position converts a byte offset into a line and column.  The offset
+// from encoding/json is just past the point of the error.
+func position(b []byte, offset int64) (int, int) {
+	if offset > int64(len(b)) {
+		offset = int64(len(b))
+	}
+	line, col := 1, 1
+	for _, c := range b[:offset] {
+		if c == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+	}
+	if col > 1 {
+		col--
+	}
+	return line, col
+}
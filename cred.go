@@ -0,0 +1,99 @@
+// Copyright 2016 The Govisor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package govisor
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+)
+
+// credential is who a process runs as.
+type credential struct {
+	uid    uint32
+	gid    uint32
+	groups []uint32
+	name   string // User name, empty if only the group was given
+	home   string
+}
+
+// lookupCredential resolves the user, group and supplementary groups,
+// which may be names or numeric IDs.  If the group is empty, the user's
+// primary group is used.  The user's own supplementary groups are always
+// included.  If the user is empty, the process runs as our own user, but
+// with the given group.
+func lookupCredential(uname, gname string, gnames []string) (*credential, error) {
+	c := &credential{}
+	if uname != "" {
+		u, e := user.Lookup(uname)
+		if e != nil {
+			if u, e = user.LookupId(uname); e != nil {
+				return nil, fmt.Errorf("Unknown user %s", uname)
+			}
+		}
+		if c.uid, e = parseID(u.Uid); e != nil {
+			return nil, e
+		}
+		if c.gid, e = parseID(u.Gid); e != nil {
+			return nil, e
+		}
+		c.name = u.Username
+		c.home = u.HomeDir
+		if ids, e := u.GroupIds(); e == nil {
+			for _, id := range ids {
+				if gid, e := parseID(id); e == nil && gid != c.gid {
+					c.groups = append(c.groups, gid)
+				}
+			}
+		}
+	} else {
+		c.uid = uint32(os.Getuid())
+		c.gid = uint32(os.Getgid())
+	}
+	if gname != "" {
+		gid, e := lookupGroup(gname)
+		if e != nil {
+			return nil, e
+		}
+		c.gid = gid
+	}
+	for _, g := range gnames {
+		gid, e := lookupGroup(g)
+		if e != nil {
+			return nil, e
+		}
+		c.groups = append(c.groups, gid)
+	}
+	return c, nil
+}
+
+func lookupGroup(name string) (uint32, error) {
+	g, e := user.LookupGroup(name)
+	if e != nil {
+		if g, e = user.LookupGroupId(name); e != nil {
+			return 0, fmt.Errorf("Unknown group %s", name)
+		}
+	}
+	return parseID(g.Gid)
+}
+
+func parseID(id string) (uint32, error) {
+	v, e := strconv.ParseUint(id, 10, 32)
+	if e != nil {
+		return 0, fmt.Errorf("Bad user or group id %s", id)
+	}
+	return uint32(v), nil
+}
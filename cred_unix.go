@@ -0,0 +1,31 @@
+// Copyright 2016 The Govisor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package govisor
+
+import (
+	"syscall"
+)
+
+// setCredential arranges for the command to run as the given user.
+func setCredential(attr *syscall.SysProcAttr, c *credential) error {
+	attr.Credential = &syscall.Credential{
+		Uid:    c.uid,
+		Gid:    c.gid,
+		Groups: c.groups,
+	}
+	return nil
+}
@@ -0,0 +1,255 @@
+// Copyright 2016 The Govisor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package govisor
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// environment is an ordered set of environment variables.  Setting a
+// variable that is already present replaces its value, but keeps its place.
+// It also carries the credential that commands run with, as that affects
+// the environment.
+type environment struct {
+	names  []string
+	values map[string]string
+	cred   *credential
+}
+
+func newEnvironment(base []string) *environment {
+	env := &environment{values: make(map[string]string)}
+	for _, kv := range base {
+		if i := strings.Index(kv, "="); i > 0 {
+			env.set(kv[:i], kv[i+1:])
+		}
+	}
+	return env
+}
+
+func (env *environment) set(name, value string) {
+	if _, ok := env.values[name]; !ok {
+		env.names = append(env.names, name)
+	}
+	env.values[name] = value
+}
+
+func (env *environment) get(name string) string {
+	return env.values[name]
+}
+
+func (env *environment) list() []string {
+	rv := make([]string, 0, len(env.names))
+	for _, n := range env.names {
+		rv = append(rv, n+"="+env.values[n])
+	}
+	return rv
+}
+
+// expandVars replaces ${NAME} with the value of NAME, or the empty string
+// if it is not set.  Unlike os.Expand, a bare $NAME is left alone, so that
+// commands meant for a shell are not disturbed, and "$${" yields a literal
+// "${".
+func expandVars(s string, lookup func(string) string) string {
+	if !strings.Contains(s, "${") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case strings.HasPrefix(s[i:], "$${"):
+			b.WriteString("${")
+			i += 2
+		case strings.HasPrefix(s[i:], "${"):
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				b.WriteString(s[i:])
+				return b.String()
+			}
+			b.WriteString(lookup(s[i+2 : i+end]))
+			i += end
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// parseEnvFile reads a file in dotenv format, adding the variables to env.
+// Each line is NAME=VALUE, optionally preceded by "export".  Blank lines
+// and lines starting with "#" are ignored.  Values in single quotes are
+// literal.  Values in double quotes may use the escapes \n, \t, \" and \\.
+// Unquoted values are trimmed, and end at a " #" comment.  ${NAME} is
+// expanded in unquoted and double quoted values.
+func parseEnvFile(r io.Reader, env *environment) error {
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		text = strings.TrimPrefix(text, "export ")
+		i := strings.Index(text, "=")
+		if i < 1 {
+			return fmt.Errorf("line %d: missing =", line)
+		}
+		name := strings.TrimSpace(text[:i])
+		value := strings.TrimSpace(text[i+1:])
+		switch {
+		case strings.HasPrefix(value, "'"):
+			end := strings.Index(value[1:], "'")
+			if end < 0 {
+				return fmt.Errorf("line %d: unterminated quote",
+					line)
+			}
+			value = value[1 : end+1]
+		case strings.HasPrefix(value, "\""):
+			v, ok := unquoteEnv(value[1:])
+			if !ok {
+				return fmt.Errorf("line %d: unterminated quote",
+					line)
+			}
+			value = expandVars(v, env.get)
+		default:
+			if j := strings.Index(value, " #"); j >= 0 {
+				value = strings.TrimSpace(value[:j])
+			}
+			value = expandVars(value, env.get)
+		}
+		env.set(name, value)
+	}
+	return scanner.Err()
+}
+
+// unquoteEnv returns the double quoted string that s starts with, less
+// the opening quote, with escapes processed.
+func unquoteEnv(s string) (string, bool) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"':
+			return b.String(), true
+		case c == '\\' && i+1 < len(s):
+			i++
+			switch s[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			default:
+				b.WriteByte(s[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", false
+}
+
+// environ returns the environment for our commands.  This is the daemon's
+// environment (unless cleanEnv is set), then HOME, USER and LOGNAME if a
+// user is set, then the variables from the environment files, and finally
+// those given by env.  Environment files whose names start with "-" are
+// optional, and skipped if missing.  The files are read each time, so that
+// changes are noticed on restart.
+func (p *Process) environ() (*environment, error) {
+	var env *environment
+	if p.cleanEnv {
+		env = newEnvironment(nil)
+	} else {
+		env = newEnvironment(os.Environ())
+	}
+	if p.user != "" || p.group != "" || len(p.groups) != 0 {
+		c, e := lookupCredential(p.user, p.group, p.groups)
+		if e != nil {
+			return nil, e
+		}
+		if c.name != "" {
+			env.set("HOME", c.home)
+			env.set("USER", c.name)
+			env.set("LOGNAME", c.name)
+		}
+		env.cred = c
+	}
+	for _, name := range p.envFiles {
+		optional := strings.HasPrefix(name, "-")
+		name = strings.TrimPrefix(name, "-")
+		f, e := os.Open(name)
+		if e != nil {
+			if optional && os.IsNotExist(e) {
+				continue
+			}
+			return nil, e
+		}
+		e = parseEnvFile(f, env)
+		f.Close()
+		if e != nil {
+			return nil, fmt.Errorf("%s: %v", name, e)
+		}
+	}
+	for _, kv := range p.env {
+		if i := strings.Index(kv, "="); i > 0 {
+			env.set(kv[:i], expandVars(kv[i+1:], env.get))
+		}
+	}
+	return env, nil
+}
+
+// command returns a copy of c, ready to run in the environment, and as
+// the credential of the environment.  ${NAME} is expanded in the arguments
+// and directory.  If c has its own environment, then that is used instead,
+// as it was given explicitly.
+func (p *Process) command(c *exec.Cmd, env *environment) (*exec.Cmd, error) {
+	cred := env.cred
+	if c.Env != nil {
+		env = newEnvironment(c.Env)
+	}
+	args := make([]string, 0, len(c.Args))
+	for _, a := range c.Args {
+		args = append(args, expandVars(a, env.get))
+	}
+	var nc *exec.Cmd
+	if len(args) != 0 && args[0] != c.Args[0] {
+		nc = exec.Command(args[0], args[1:]...)
+	} else {
+		nc = &exec.Cmd{}
+		*nc = *c
+		nc.Args = args
+	}
+	nc.Dir = expandVars(c.Dir, env.get)
+	nc.Env = env.list()
+	nc.Stdin = c.Stdin
+	nc.Stdout = c.Stdout
+	nc.Stderr = c.Stderr
+	nc.ExtraFiles = c.ExtraFiles
+	nc.SysProcAttr = c.SysProcAttr
+	if cred != nil {
+		nc.SysProcAttr = &syscall.SysProcAttr{}
+		if c.SysProcAttr != nil {
+			*nc.SysProcAttr = *c.SysProcAttr
+		}
+		if e := setCredential(nc.SysProcAttr, cred); e != nil {
+			return nil, e
+		}
+	}
+	return nc, nil
+}
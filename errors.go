@@ -29,4 +29,49 @@ var (
 	ErrPropReadOnly = errors.New("Property not changeable")
 	ErrRateLimited  = errors.New("Restarting too quickly")
 	ErrNameExists   = errors.New("Service name already exists")
+	ErrMasked       = errors.New("Service is masked")
+	ErrNoServiceDir = errors.New("No services directory")
+	ErrNotSupported = errors.New("Not supported on this platform")
+	ErrNoTemplate   = errors.New("No such template")
+	ErrNoInstance   = errors.New("No such instance")
+	ErrBadInstance  = errors.New("Bad instance name")
+	ErrNoReload     = errors.New("Service cannot be reloaded")
+	ErrNoSignal     = errors.New("Service cannot be signaled")
+	ErrNoGroup      = errors.New("Service has no process group")
+	ErrBadRecordId  = errors.New("Log record ID out of order")
+	ErrNoLauncher   = errors.New("Resource controls need RunLauncher")
 )
+
+// FaultKind classifies a failure, so that the restart policy can decide
+// whether the service should be restarted.
+type FaultKind int
+
+const (
+	FaultFailure  FaultKind = iota // Non-zero exit, or failure to start
+	FaultExit                      // Clean exit, with a zero status
+	FaultAbnormal                  // Killed by a signal, or unhealthy
+)
+
+// Fault is an error that carries a FaultKind.  Providers can return these
+// from Start or Check.  Errors that are not a Fault are treated as being
+// of kind FaultFailure.
+type Fault struct {
+	Kind FaultKind
+	Err  error
+}
+
+func (f *Fault) Error() string {
+	return f.Err.Error()
+}
+
+func (f *Fault) Unwrap() error {
+	return f.Err
+}
+
+func faultKind(e error) FaultKind {
+	var f *Fault
+	if errors.As(e, &f) {
+		return f.Kind
+	}
+	return FaultFailure
+}
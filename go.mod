@@ -3,9 +3,12 @@ module github.com/gdamore/govisor
 go 1.14
 
 require (
+	github.com/BurntSushi/toml v1.2.1
 	github.com/gdamore/tcell/v2 v2.7.1
 	github.com/gorilla/mux v1.7.4
 	github.com/smartystreets/goconvey v1.6.4
 	golang.org/x/crypto v0.17.0
 	golang.org/x/net v0.17.0
+	golang.org/x/sys v0.17.0
+	gopkg.in/yaml.v3 v3.0.1
 )
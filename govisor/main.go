@@ -30,7 +30,28 @@
 //      disable <svc>       - disable the named service
 //      restart <svc>       - restart the named service
 //      clear <svc>         - clear the named service
-//      log <svc>           - obtain the log for the named service
+//      mask <svc>          - disable the named service, and prevent enabling
+//      unmask <svc>        - allow the named service to be enabled again
+//      reload              - rescan the service manifests
+//      reload <svc>        - reload the named service's configuration
+//      signal [-g] <svc> <sig> - send a signal, e.g. SIGUSR1, to the named
+//                            service (with -g, to its whole process group)
+//      instantiate <t@i>   - create instance i of template t
+//      uninstantiate <t@i> - remove an instance created by instantiate
+//      log [<svc>]         - obtain the log for the named service (or all)
+//          -stream <list>  - only show these streams (stdout, stderr,
+//                            supervisor, or output for stdout and stderr)
+//          -level <level>  - only show messages at least this severe
+//                            (info, warning or error)
+//          -color=false    - do not colorize, even on a terminal
+//          -grep <regexp>  - only show records matching the expression
+//          -F              - match -grep as plain text
+//          -since <time>   - only show records from this time, given as a
+//                            duration ago such as 1h, or a date and time
+//          -until <time>   - only show records up to this time
+//          -n <count>      - only show this many of the newest records
+//                            (the server searches its journal, if it has
+//                            one, for any of the above)
 //
 package main
 
@@ -91,6 +112,55 @@ func loadCertFile(roots *x509.CertPool, fname string) error {
 	return err
 }
 
+// isTerminal returns true if f is a terminal, or at least a character
+// device.
+func isTerminal(f *os.File) bool {
+	fi, e := f.Stat()
+	return e == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// colorize wraps a log line in the ANSI color for its stream and level.
+// Process output is shown as is, except that stderr is yellow.  Messages
+// from govisor are cyan, or yellow for warnings and red for errors.
+func colorize(r *rest.LogRecord, line string) string {
+	color := ""
+	switch {
+	case r.Level == "error":
+		color = "31"
+	case r.Level == "warning", r.Stream == "stderr":
+		color = "33"
+	case r.Stream == "supervisor":
+		color = "36"
+	}
+	if color == "" {
+		return line
+	}
+	return "\x1b[" + color + "m" + line + "\x1b[0m"
+}
+
+// parseLogTime parses a time given to the log subcommand, which is either
+// a duration such as "10m", meaning that long ago, or a time in RFC 3339
+// format, or a local date and time such as "2006-01-02 15:04:05", with
+// the time optional.  The empty string is the zero time.
+func parseLogTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, e := time.ParseDuration(s); e == nil {
+		return time.Now().Add(-d), nil
+	}
+	if t, e := time.Parse(time.RFC3339Nano, s); e == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05",
+		"2006-01-02 15:04", "2006-01-02"} {
+		if t, e := time.ParseInLocation(layout, s, time.Local); e == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unknown time %q", s)
+}
+
 func fatal(f string, e error) {
 	msg := e.Error()
 	if len(msg) > 4 && msg[0] == '4' {
@@ -219,22 +289,138 @@ func main() {
 			fatal("Error", e)
 		}
 
-	case "log":
-		loginfo := &rest.LogInfo{}
-		switch len(args) {
-		case 0:
+	case "mask":
+		if len(args) != 2 {
+			usage()
+		}
+		e := client.MaskService(args[1])
+		if e != nil {
+			fatal("Error", e)
+		}
+
+	case "unmask":
+		if len(args) != 2 {
 			usage()
+		}
+		e := client.UnmaskService(args[1])
+		if e != nil {
+			fatal("Error", e)
+		}
+
+	case "reload":
+		var e error
+		switch len(args) {
 		case 1:
-			loginfo, e = client.GetLog("")
+			e = client.Reload()
 		case 2:
-			loginfo, e = client.GetLog(args[1])
+			e = client.ReloadService(args[1])
+		default:
+			usage()
 		}
 		if e != nil {
 			fatal("Error", e)
 		}
-		for _, line := range loginfo.Records {
-			fmt.Printf("%s %s\n",
-				line.Time.Format(time.StampMilli), line.Text)
+
+	case "signal":
+		group := len(args) == 4 && args[1] == "-g"
+		if group {
+			args = args[1:]
+		}
+		if len(args) != 3 {
+			usage()
+		}
+		e := client.SignalService(args[1], args[2], group)
+		if e != nil {
+			fatal("Error", e)
+		}
+
+	case "instantiate":
+		if len(args) != 2 {
+			usage()
+		}
+		e := client.Instantiate(args[1])
+		if e != nil {
+			fatal("Error", e)
+		}
+
+	case "uninstantiate":
+		if len(args) != 2 {
+			usage()
+		}
+		e := client.Uninstantiate(args[1])
+		if e != nil {
+			fatal("Error", e)
+		}
+
+	case "log":
+		fs := flag.NewFlagSet("log", flag.ExitOnError)
+		streams := fs.String("stream", "",
+			"streams to show: stdout, stderr, supervisor or output")
+		level := fs.String("level", "",
+			"least severe level to show: info, warning or error")
+		color := fs.Bool("color", isTerminal(os.Stdout),
+			"colorize by stream and level")
+		grep := fs.String("grep", "",
+			"show only records matching the regular expression")
+		fixed := fs.Bool("F", false, "match -grep as plain text")
+		since := fs.String("since", "",
+			"show records from this time, or this long ago")
+		until := fs.String("until", "",
+			"show records up to this time, or this long ago")
+		tail := fs.Int("n", 0, "show at most this many of the newest records")
+		fs.Parse(args[1:])
+		filter, e := util.NewLogFilter(*streams, *level)
+		if e != nil {
+			fatal("Bad filter", e)
+		}
+		name := ""
+		switch fs.NArg() {
+		case 0:
+		case 1:
+			name = fs.Arg(0)
+		default:
+			usage()
+		}
+		var recs []rest.LogRecord
+		if *grep != "" || *since != "" || *until != "" || *tail > 0 {
+			// Let the server do the work, as it may have a journal
+			// with far more than we would otherwise get.
+			q := rest.LogQuery{
+				Streams: filter.Streams,
+				Level:   filter.Level,
+				Tail:    *tail,
+			}
+			if *fixed {
+				q.Match = *grep
+			} else {
+				q.Regexp = *grep
+			}
+			if q.From, e = parseLogTime(*since); e != nil {
+				fatal("Bad since", e)
+			}
+			if q.To, e = parseLogTime(*until); e != nil {
+				fatal("Bad until", e)
+			}
+			if recs, e = client.SearchLog(name, q); e != nil {
+				fatal("Error", e)
+			}
+		} else {
+			loginfo, e := client.GetLog(name)
+			if e != nil {
+				fatal("Error", e)
+			}
+			recs = loginfo.Records
+		}
+		for i := range recs {
+			r := &recs[i]
+			if !filter.Match(r) {
+				continue
+			}
+			line := r.Time.Format(time.StampMilli) + " " + r.String()
+			if *color {
+				line = colorize(r, line)
+			}
+			fmt.Println(line)
 		}
 	case "info":
 		if len(args) != 2 {
@@ -249,6 +435,20 @@ func main() {
 		fmt.Printf("Status:    %s\n", util.Status(s))
 		fmt.Printf("Since:     %v\n", time.Now().Sub(s.TimeStamp))
 		fmt.Printf("Detail:    %s\n", s.Status)
+		if !s.NextRestart.IsZero() {
+			fmt.Printf("Restart:   %v\n", time.Until(s.NextRestart))
+		}
+		if s.Schedule != "" {
+			fmt.Printf("Schedule:  %s\n", s.Schedule)
+			if !s.LastRun.IsZero() {
+				fmt.Printf("Last Run:  %v (%s)\n",
+					s.LastRun.Format(time.Stamp), s.LastResult)
+			}
+			if !s.NextRun.IsZero() {
+				fmt.Printf("Next Run:  %v\n",
+					s.NextRun.Format(time.Stamp))
+			}
+		}
 		fmt.Printf("Provides: ")
 		for _, p := range s.Provides {
 			fmt.Printf(" %s", p)
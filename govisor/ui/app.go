@@ -125,6 +125,10 @@ func (a *App) RestartService(name string) {
 	a.client.RestartService(name)
 }
 
+func (a *App) ReloadService(name string) {
+	a.client.ReloadService(name)
+}
+
 func (a *App) Quit() {
 	/* This just posts the quit event. */
 	a.app.Quit()
@@ -68,8 +68,10 @@ func (h *HelpPanel) Init(app *App) {
 		"  <D>            : disable selected service",
 		"  <I>            : view detailed information for service",
 		"  <R>            : restart selected service",
+		"  <O>            : reload configuration of selected service",
 		"  <C>            : clear faults on selected service",
 		"  <L>            : view log for selected service",
+		"  <F>            : change which log messages are shown",
 		"",
 		"This program is distributed under the Apache 2.0 License",
 		"Copyright 2016 The Govisor Authors",
@@ -83,6 +83,11 @@ func (i *InfoPanel) HandleEvent(ev tcell.Event) bool {
 					i.App().RestartService(info.Name)
 					return true
 				}
+			case 'O', 'o':
+				if info != nil && info.Running {
+					i.App().ReloadService(info.Name)
+					return true
+				}
 			case 'E', 'e':
 				if info != nil && !info.Enabled {
 					i.App().EnableService(info.Name)
@@ -153,6 +158,22 @@ func (i *InfoPanel) update() {
 	lines = append(lines, fmt.Sprintf("%13s %s", "Status:", util.Status(s)))
 	lines = append(lines, fmt.Sprintf("%13s %v", "Since:", s.TimeStamp))
 	lines = append(lines, fmt.Sprintf("%13s %s", "Detail:", s.Status))
+	if !s.NextRestart.IsZero() {
+		lines = append(lines, fmt.Sprintf("%13s %v", "Next Restart:",
+			s.NextRestart))
+	}
+	if s.Schedule != "" {
+		lines = append(lines, fmt.Sprintf("%13s %s", "Schedule:",
+			s.Schedule))
+		if !s.LastRun.IsZero() {
+			lines = append(lines, fmt.Sprintf("%13s %v (%s)",
+				"Last Run:", s.LastRun, s.LastResult))
+		}
+		if !s.NextRun.IsZero() {
+			lines = append(lines, fmt.Sprintf("%13s %v",
+				"Next Run:", s.NextRun))
+		}
+	}
 
 	l := fmt.Sprintf("%13s", "Provides:")
 	for _, p := range s.Provides {
@@ -183,6 +204,9 @@ func (i *InfoPanel) update() {
 			words = append(words, "[C] Clear")
 		}
 		words = append(words, "[R] Restart")
+		if s.Running {
+			words = append(words, "[O] Reload")
+		}
 	}
 	i.SetKeys(words)
 }
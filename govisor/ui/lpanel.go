@@ -21,18 +21,128 @@ import (
 	"github.com/gdamore/tcell/v2"
 	"github.com/gdamore/tcell/v2/views"
 
+	"github.com/gdamore/govisor/govisor/util"
 	"github.com/gdamore/govisor/rest"
 )
 
+// Log filters, cycled through with the [F] key.
+var logFilters = []struct {
+	name    string
+	streams string
+	level   string
+}{
+	{"All", "", ""},
+	{"Output", "output", ""},
+	{"Supervisor", "supervisor", ""},
+	{"Warnings", "", "warning"},
+}
+
+// StyleInfo is used for messages from govisor itself.
+var StyleInfo = tcell.StyleDefault.
+	Foreground(tcell.ColorTeal).
+	Background(tcell.ColorBlack)
+
 type LogPanel struct {
-	text *views.TextArea
-	info *rest.ServiceInfo
-	name string // service name
-	err  error  // last error retrieving state
+	text   *views.CellView
+	model  *logModel
+	info   *rest.ServiceInfo
+	name   string // service name
+	err    error  // last error retrieving state
+	filter int    // index into logFilters
 
 	Panel
 }
 
+// logModel provides the model for the log.  It is like the model of a
+// TextArea, but with a style for each line.
+type logModel struct {
+	lines  [][]rune
+	styles []tcell.Style
+	width  int
+	x      int
+	y      int
+}
+
+func (m *logModel) GetCell(x, y int) (rune, tcell.Style, []rune, int) {
+	if x < 0 || y < 0 || y >= len(m.lines) || x >= len(m.lines[y]) {
+		return 0, StyleNormal, nil, 1
+	}
+	return m.lines[y][x], m.styles[y], nil, 1
+}
+
+func (m *logModel) GetBounds() (int, int) {
+	return m.width, len(m.lines)
+}
+
+func (m *logModel) limitCursor() {
+	if m.x > m.width-1 {
+		m.x = m.width - 1
+	}
+	if m.y > len(m.lines)-1 {
+		m.y = len(m.lines) - 1
+	}
+	if m.x < 0 {
+		m.x = 0
+	}
+	if m.y < 0 {
+		m.y = 0
+	}
+}
+
+func (m *logModel) SetCursor(x, y int) {
+	m.x = x
+	m.y = y
+	m.limitCursor()
+}
+
+func (m *logModel) MoveCursor(x, y int) {
+	m.x += x
+	m.y += y
+	m.limitCursor()
+}
+
+func (m *logModel) GetCursor() (int, int, bool, bool) {
+	return m.x, m.y, false, false
+}
+
+// logStyle returns the style for a record, based upon its stream and
+// level.
+func logStyle(r *rest.LogRecord) tcell.Style {
+	switch {
+	case r.Level == "error":
+		return StyleError
+	case r.Level == "warning", r.Stream == "stderr":
+		return StyleWarn
+	case r.Stream == "supervisor":
+		return StyleInfo
+	}
+	return StyleNormal
+}
+
+// setRecords replaces the content with the records that the filter
+// selects.
+func (p *LogPanel) setRecords(recs []rest.LogRecord) {
+	f := logFilters[p.filter]
+	filter, _ := util.NewLogFilter(f.streams, f.level)
+	m := p.model
+	m.lines = m.lines[:0]
+	m.styles = m.styles[:0]
+	m.width = 0
+	for i := range recs {
+		r := &recs[i]
+		if !filter.Match(r) {
+			continue
+		}
+		line := []rune(r.Time.Format(time.StampMilli) + " " + r.String())
+		if len(line) > m.width {
+			m.width = len(line)
+		}
+		m.lines = append(m.lines, line)
+		m.styles = append(m.styles, logStyle(r))
+	}
+	p.text.SetModel(m)
+}
+
 func NewLogPanel(app *App) *LogPanel {
 	p := &LogPanel{}
 
@@ -41,10 +151,10 @@ func NewLogPanel(app *App) *LogPanel {
 	// We don't change the keybar, so set it once
 	p.SetKeys([]string{"[Q] Quit", "[H] Help"})
 
-	p.text = views.NewTextArea()
-	p.text.EnableCursor(false)
-	p.text.SetStyle(tcell.StyleDefault.
-		Foreground(tcell.ColorSilver).Background(tcell.ColorBlack))
+	p.model = &logModel{}
+	p.text = views.NewCellView()
+	p.text.SetModel(p.model)
+	p.text.SetStyle(StyleNormal)
 	p.SetContent(p.text)
 	p.update()
 
@@ -76,6 +186,9 @@ func (p *LogPanel) HandleEvent(ev tcell.Event) bool {
 			case 'H', 'h':
 				app.ShowHelp()
 				return true
+			case 'F', 'f':
+				p.filter = (p.filter + 1) % len(logFilters)
+				return true
 			case 'I', 'i':
 				if info != nil {
 					app.ShowInfo(info.Name)
@@ -86,6 +199,11 @@ func (p *LogPanel) HandleEvent(ev tcell.Event) bool {
 					app.RestartService(info.Name)
 					return true
 				}
+			case 'O', 'o':
+				if info != nil && info.Running {
+					app.ReloadService(info.Name)
+					return true
+				}
 			case 'E', 'e':
 				if info != nil && !info.Enabled {
 					app.EnableService(info.Name)
@@ -109,7 +227,7 @@ func (p *LogPanel) HandleEvent(ev tcell.Event) bool {
 
 func (p *LogPanel) SetName(name string) {
 	p.SetTitle("Loading")
-	p.text.SetLines(nil)
+	p.setRecords(nil)
 	p.name = name
 }
 
@@ -121,6 +239,7 @@ func (p *LogPanel) update() {
 	p.info = svcinfo
 
 	words := []string{"[ESC] Main", "[H] Help"}
+	words = append(words, "[F] Filter: "+logFilters[p.filter].name)
 
 	if p.name == "" {
 		p.SetTitle("Consolidated Log")
@@ -140,12 +259,15 @@ func (p *LogPanel) update() {
 			p.SetStatus("Loading ...")
 			p.SetNormal()
 		}
-		p.text.SetLines([]string{""})
+		p.setRecords(nil)
 		p.SetKeys(words)
 		return
 	}
 
 	p.SetStatus("")
+	if loginfo.Lost {
+		p.SetStatus("Fell behind, some records were missed")
+	}
 	if svcinfo != nil {
 		if !svcinfo.Enabled {
 			p.SetNormal()
@@ -158,13 +280,7 @@ func (p *LogPanel) update() {
 		}
 	}
 
-	lines := make([]string, 0, len(loginfo.Records))
-	for _, r := range loginfo.Records {
-		line := fmt.Sprintf("%s %s",
-			r.Time.Format(time.StampMilli), r.Text)
-		lines = append(lines, line)
-	}
-	p.text.SetLines(lines)
+	p.setRecords(loginfo.Records)
 
 	if svcinfo != nil {
 		words = append(words, "[I] Info")
@@ -176,6 +292,9 @@ func (p *LogPanel) update() {
 				words = append(words, "[C] Clear")
 			}
 			words = append(words, "[R] Restart")
+			if svcinfo.Running {
+				words = append(words, "[O] Reload")
+			}
 		}
 	}
 	p.SetKeys(words)
@@ -173,6 +173,11 @@ func (m *MainPanel) HandleEvent(ev tcell.Event) bool {
 					m.App().RestartService(m.selected.Name)
 					return true
 				}
+			case 'O', 'o':
+				if m.selected != nil && m.selected.Running {
+					m.App().ReloadService(m.selected.Name)
+					return true
+				}
 			}
 		}
 	}
@@ -371,6 +376,9 @@ func (m *MainPanel) update() {
 				words = append(words, "[C] Clear")
 			}
 			words = append(words, "[R] Restart")
+			if item.Running {
+				words = append(words, "[O] Reload")
+			}
 		}
 	} else {
 		words = append(words, "[L] Log")
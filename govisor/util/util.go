@@ -18,18 +18,25 @@ package util
 import (
 	"fmt"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/gdamore/govisor/rest"
 )
 
 func Status(s *rest.ServiceInfo) string {
+	if s.Masked {
+		return "masked"
+	}
 	if !s.Enabled {
 		return "disabled"
 	}
 	if s.Failed {
 		return "failed"
 	}
+	if s.Completed {
+		return "completed"
+	}
 	if s.Running {
 		return "running"
 	}
@@ -75,3 +82,44 @@ func (s sorted) Less(i, j int) bool {
 func SortServices(items []*rest.ServiceInfo) {
 	sort.Sort(sorted(items))
 }
+
+// LogFilter selects log records.  Empty fields select every record.
+type LogFilter struct {
+	Streams []string // Streams to show: stdout, stderr or supervisor
+	Level   string   // Least severe level of supervisor message to show
+}
+
+// NewLogFilter returns a filter for a comma separated list of streams,
+// and a level.  As a shorthand, the stream "output" selects both stdout
+// and stderr.
+func NewLogFilter(streams string, level string) (LogFilter, error) {
+	f := LogFilter{Level: level}
+	// Every known level is at least the least severe.
+	if level != "" && !rest.LevelAtLeast(level, rest.LevelInfo) {
+		return f, fmt.Errorf("unknown level %q", level)
+	}
+	if streams == "" {
+		return f, nil
+	}
+	for _, s := range strings.Split(streams, ",") {
+		switch s {
+		case rest.StreamStdout, rest.StreamStderr,
+			rest.StreamSupervisor:
+			f.Streams = append(f.Streams, s)
+		case "output":
+			f.Streams = append(f.Streams,
+				rest.StreamStdout, rest.StreamStderr)
+		default:
+			return f, fmt.Errorf("unknown stream %q", s)
+		}
+	}
+	return f, nil
+}
+
+// Match returns true if the filter selects the record, in the same way as
+// the server does.  Records without a level, such as process output,
+// are not selected if a level is given.
+func (f LogFilter) Match(r *rest.LogRecord) bool {
+	return rest.LevelAtLeast(r.Level, f.Level) &&
+		rest.MatchStream(r.Stream, f.Streams)
+}
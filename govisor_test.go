@@ -16,15 +16,28 @@ package govisor
 
 import (
 	"errors"
+	"fmt"
+	"io/ioutil"
 	"log"
+	"os"
+	"path/filepath"
+	"regexp"
 	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/gdamore/govisor/rest"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
+func TestMain(m *testing.M) {
+	// Services with resource controls run the test binary as the
+	// launcher.
+	RunLauncher()
+	os.Exit(m.Run())
+}
+
 type testLog struct {
 	t *testing.T
 }
@@ -415,3 +428,696 @@ func TestGovisor(t *testing.T) {
 		})
 	})
 }
+
+func TestRestartBackoff(t *testing.T) {
+	Convey("Restart backoff", t,
+		WithManager(t, "Backoff", func(m *Manager) {
+			t1 := &testS{name: "test:backoff"}
+			s1 := NewService(t1)
+			So(s1, ShouldNotBeNil)
+			So(s1.SetProperty(PropRestart, true), ShouldBeNil)
+			So(s1.SetProperty(PropRestartDelay,
+				time.Millisecond*200), ShouldBeNil)
+			So(s1.SetProperty(PropRestartJitter, 0.0), ShouldBeNil)
+			m.AddService(s1)
+			m.StopMonitoring()
+			So(s1.Enable(), ShouldBeNil)
+			So(s1.Running(), ShouldBeTrue)
+
+			// The first restart is immediate, but fails because
+			// the failure is still injected.
+			t1.inject()
+			time.Sleep(time.Millisecond * 10)
+			So(s1.Failed(), ShouldBeTrue)
+			So(s1.NextRestart().IsZero(), ShouldBeTrue)
+
+			// The second one has to wait.
+			t1.clear()
+			time.Sleep(time.Millisecond * 10)
+			So(s1.Failed(), ShouldBeTrue)
+			next := s1.NextRestart()
+			So(next.IsZero(), ShouldBeFalse)
+			So(time.Until(next), ShouldBeGreaterThan,
+				time.Millisecond*100)
+
+			time.Sleep(time.Until(next))
+			m.StartMonitoring()
+			time.Sleep(time.Second)
+			So(s1.Failed(), ShouldBeFalse)
+			So(s1.Running(), ShouldBeTrue)
+			So(s1.NextRestart().IsZero(), ShouldBeTrue)
+		}))
+}
+
+func TestRestartModes(t *testing.T) {
+	Convey("Restart modes", t,
+		WithManager(t, "RestartModes", func(m *Manager) {
+			t1 := &testS{name: "test:modes"}
+			s1 := NewService(t1)
+			So(s1, ShouldNotBeNil)
+			m.AddService(s1)
+			m.StopMonitoring()
+
+			v, e := s1.GetProperty(PropRestart)
+			So(e, ShouldBeNil)
+			So(v, ShouldEqual, RestartNever)
+
+			e = s1.SetProperty(PropRestart, RestartMode("bogus"))
+			So(e, ShouldEqual, ErrBadPropValue)
+
+			So(s1.Enable(), ShouldBeNil)
+			So(s1.Running(), ShouldBeTrue)
+
+			Convey("On-abnormal ignores plain failures", func() {
+				e := s1.SetProperty(PropRestart, RestartOnAbnormal)
+				So(e, ShouldBeNil)
+				t1.inject()
+				time.Sleep(time.Millisecond * 10)
+				t1.clear()
+				time.Sleep(time.Millisecond * 10)
+				So(s1.Failed(), ShouldBeTrue)
+				So(s1.Running(), ShouldBeFalse)
+			})
+
+			Convey("On-failure restarts plain failures", func() {
+				e := s1.SetProperty(PropRestart, RestartOnFailure)
+				So(e, ShouldBeNil)
+				v, e := s1.GetProperty(PropRestart)
+				So(e, ShouldBeNil)
+				So(v, ShouldEqual, RestartOnFailure)
+				t1.inject()
+				time.Sleep(time.Millisecond * 10)
+				t1.clear()
+				time.Sleep(time.Millisecond * 10)
+				So(s1.Failed(), ShouldBeFalse)
+				So(s1.Running(), ShouldBeTrue)
+			})
+
+			Convey("Attempts are limited", func() {
+				e := s1.SetProperty(PropRestart, RestartOnFailure)
+				So(e, ShouldBeNil)
+				e = s1.SetProperty(PropRestartAttempts, 1)
+				So(e, ShouldBeNil)
+
+				// The only attempt fails, as the fault is
+				// still injected.
+				t1.inject()
+				time.Sleep(time.Millisecond * 10)
+				t1.clear()
+				time.Sleep(time.Millisecond * 10)
+				So(s1.Failed(), ShouldBeTrue)
+				So(s1.Running(), ShouldBeFalse)
+				status, _ := s1.Status()
+				So(status, ShouldStartWith, "Restart limit reached")
+
+				s1.Clear()
+				So(s1.Failed(), ShouldBeFalse)
+				So(s1.Running(), ShouldBeTrue)
+			})
+		}))
+}
+
+func TestSchedule(t *testing.T) {
+	Convey("Parsing and evaluating schedules", t, func() {
+		at := func(s string) time.Time {
+			t, e := time.ParseInLocation("2006-01-02 15:04", s, time.Local)
+			So(e, ShouldBeNil)
+			return t
+		}
+		next := func(spec string, from string) string {
+			sched, e := ParseSchedule(spec)
+			So(e, ShouldBeNil)
+			return sched.Next(at(from)).Format("2006-01-02 15:04")
+		}
+		So(next("*/15 * * * *", "2016-03-01 10:07"), ShouldEqual,
+			"2016-03-01 10:15")
+		So(next("30 2 * * *", "2016-03-01 10:07"), ShouldEqual,
+			"2016-03-02 02:30")
+		So(next("0 0 1 jan *", "2016-03-01 10:07"), ShouldEqual,
+			"2017-01-01 00:00")
+		So(next("0 9 * * mon-fri", "2016-03-05 10:07"), ShouldEqual,
+			"2016-03-07 09:00")
+		So(next("@daily", "2016-03-01 10:07"), ShouldEqual,
+			"2016-03-02 00:00")
+		So(next("@every 90m", "2016-03-01 10:07"), ShouldEqual,
+			"2016-03-01 11:37")
+
+		for _, bad := range []string{"", "* * *", "61 * * * *",
+			"* * * * mon-", "*/0 * * * *", "@every soon"} {
+			_, e := ParseSchedule(bad)
+			So(e, ShouldNotBeNil)
+		}
+
+		sched, _ := ParseSchedule("0 0 30 feb *")
+		So(sched.Next(at("2016-03-01 10:07")).IsZero(), ShouldBeTrue)
+	})
+
+	Convey("Scheduled services run on schedule", t, func() {
+		m := NewManager("TestSchedule")
+		SetTestLogger(t, m)
+		m.StartMonitoring()
+		Reset(func() {
+			m.Shutdown()
+		})
+		t1 := &testS{name: "sched"}
+		s1 := NewService(t1)
+		So(s1.SetProperty(PropSchedule, "bogus"), ShouldEqual,
+			ErrBadPropValue)
+		So(s1.SetProperty(PropSchedule, "@every 1s"), ShouldBeNil)
+		So(s1.Schedule(), ShouldEqual, "@every 1s")
+		m.AddService(s1)
+		So(s1.Enable(), ShouldBeNil)
+		So(s1.Running(), ShouldBeFalse)
+		_, next, _ := s1.LastRun()
+		So(next.IsZero(), ShouldBeFalse)
+
+		time.Sleep(time.Millisecond * 1700)
+		So(s1.Running(), ShouldBeTrue)
+		last, _, result := s1.LastRun()
+		So(last.IsZero(), ShouldBeFalse)
+		So(result, ShouldEqual, "Running")
+
+		// Still running, so the next run is skipped.
+		time.Sleep(time.Second)
+		last2, _, _ := s1.LastRun()
+		So(last2, ShouldEqual, last)
+
+		So(s1.Disable(), ShouldBeNil)
+		_, next, result = s1.LastRun()
+		So(next.IsZero(), ShouldBeTrue)
+		So(result, ShouldEqual, "Disabled")
+	})
+}
+
+func TestState(t *testing.T) {
+	Convey("Service state persists across managers", t, func() {
+		dir, e := ioutil.TempDir("", "govisor")
+		So(e, ShouldBeNil)
+		Reset(func() {
+			os.RemoveAll(dir)
+		})
+		fname := filepath.Join(dir, "state", "state.json")
+
+		// The default is kept apart for each name.
+		old, had := os.LookupEnv("GOVISORDIR")
+		os.Setenv("GOVISORDIR", dir)
+		m1 := NewManager("TestState1")
+		if had {
+			os.Setenv("GOVISORDIR", old)
+		} else {
+			os.Unsetenv("GOVISORDIR")
+		}
+		SetTestLogger(t, m1)
+		So(m1.DefaultStateFile(), ShouldEqual,
+			filepath.Join(dir, "TestState1", "state.json"))
+		var s1 []*Service
+		for _, n := range []string{"a", "b", "c", "d"} {
+			s := NewService(&testS{name: n, failed: n == "d"})
+			So(m1.AddService(s), ShouldBeNil)
+			s1 = append(s1, s)
+		}
+		m1.SetStateFile(fname)
+		So(m1.StateFile(), ShouldEqual, fname)
+		So(m1.RestoreState(false), ShouldBeNil)
+		So(s1[0].Enable(), ShouldBeNil)
+		So(s1[1].Mask(), ShouldBeNil)
+		So(s1[1].Enable(), ShouldEqual, ErrMasked)
+		So(s1[3].Enable(), ShouldBeNil)
+		So(s1[3].Failed(), ShouldBeTrue)
+		m1.Shutdown()
+
+		_, e = os.Stat(fname)
+		So(e, ShouldBeNil)
+
+		m2 := NewManager("TestState2")
+		SetTestLogger(t, m2)
+		Reset(func() {
+			m2.Shutdown()
+		})
+		var s2 []*Service
+		for _, n := range []string{"a", "b", "c", "d", "e"} {
+			s := NewService(&testS{name: n})
+			So(m2.AddService(s), ShouldBeNil)
+			s2 = append(s2, s)
+		}
+		m2.SetStateFile(fname)
+		So(m2.RestoreState(true), ShouldBeNil)
+
+		So(s2[0].Running(), ShouldBeTrue)
+		So(s2[1].Masked(), ShouldBeTrue)
+		So(s2[1].Enabled(), ShouldBeFalse)
+		So(s2[2].Enabled(), ShouldBeFalse)
+		So(s2[3].Failed(), ShouldBeTrue)
+		So(s2[3].Running(), ShouldBeFalse)
+		So(s2[4].Running(), ShouldBeTrue)
+
+		s2[3].Clear()
+		So(s2[3].Running(), ShouldBeTrue)
+		So(s2[1].Unmask(), ShouldBeNil)
+		So(s2[1].Enable(), ShouldBeNil)
+		So(s2[1].Running(), ShouldBeTrue)
+	})
+
+	Convey("A bad state file still enables services", t, func() {
+		dir, e := ioutil.TempDir("", "govisor")
+		So(e, ShouldBeNil)
+		Reset(func() {
+			os.RemoveAll(dir)
+		})
+		fname := filepath.Join(dir, "state.json")
+		So(ioutil.WriteFile(fname, []byte("garbage"), 0644), ShouldBeNil)
+
+		m := NewManager("TestStateBad")
+		SetTestLogger(t, m)
+		Reset(func() {
+			m.Shutdown()
+		})
+		s := NewService(&testS{name: "a"})
+		So(m.AddService(s), ShouldBeNil)
+		m.SetStateFile(fname)
+		So(m.RestoreState(true), ShouldNotBeNil)
+		So(s.Running(), ShouldBeTrue)
+	})
+}
+
+func TestLogFile(t *testing.T) {
+	Convey("Test log files and rotation", t, func() {
+		dir, e := ioutil.TempDir("", "govisor")
+		So(e, ShouldBeNil)
+		Reset(func() {
+			os.RemoveAll(dir)
+		})
+		name := filepath.Join(dir, "sub", "test.log")
+		lf, e := OpenLogFile(name, LogRotation{MaxSize: 10, Keep: 2})
+		So(e, ShouldBeNil)
+		Reset(func() {
+			lf.Close()
+		})
+		line := func(s string) {
+			_, e := lf.Write([]byte(s + "\n"))
+			So(e, ShouldBeNil)
+		}
+		content := func(name string) string {
+			b, _ := ioutil.ReadFile(name)
+			return string(b)
+		}
+
+		line("one")
+		line("two")
+		So(content(name), ShouldEqual, "one\ntwo\n")
+		line("three")
+		So(content(name), ShouldEqual, "three\n")
+		So(content(name+".1"), ShouldEqual, "one\ntwo\n")
+		line("four")
+		line("five")
+		line("six")
+		So(content(name), ShouldEqual, "six\n")
+		So(content(name+".1"), ShouldEqual, "four\nfive\n")
+		So(content(name+".2"), ShouldEqual, "three\n")
+		_, e = os.Stat(name + ".3")
+		So(os.IsNotExist(e), ShouldBeTrue)
+
+		Convey("Reopen follows a moved file", func() {
+			So(os.Rename(name, name+".old"), ShouldBeNil)
+			So(lf.Reopen(), ShouldBeNil)
+			line("seven")
+			So(content(name), ShouldEqual, "seven\n")
+			So(content(name+".old"), ShouldEqual, "six\n")
+		})
+
+		Convey("Rotated files can be compressed", func() {
+			lf2, e := OpenLogFile(name, LogRotation{Compress: true})
+			So(e, ShouldBeNil)
+			So(lf2.Rotate(), ShouldBeNil)
+			So(lf2.Close(), ShouldBeNil)
+			_, e = os.Stat(name + ".1.gz")
+			So(e, ShouldBeNil)
+			_, e = os.Stat(name + ".1")
+			So(os.IsNotExist(e), ShouldBeTrue)
+		})
+
+		Convey("Closed files cannot be written", func() {
+			So(lf.Close(), ShouldBeNil)
+			_, e := lf.Write([]byte("eight\n"))
+			So(e, ShouldEqual, os.ErrClosed)
+		})
+	})
+
+	Convey("Test service log files", t, func() {
+		dir, e := ioutil.TempDir("", "govisor")
+		So(e, ShouldBeNil)
+		m := NewManager("TestLogFile")
+		SetTestLogger(t, m)
+		Reset(func() {
+			m.Shutdown()
+			os.RemoveAll(dir)
+		})
+		m.SetLogDir(dir)
+		So(m.LogDir(), ShouldEqual, dir)
+		s := NewService(&testS{name: "a"})
+		So(s.SetProperty(PropLogFile, "a.log"), ShouldBeNil)
+		So(m.AddService(s), ShouldBeNil)
+		So(s.Enable(), ShouldBeNil)
+		b, e := ioutil.ReadFile(filepath.Join(dir, "a.log"))
+		So(e, ShouldBeNil)
+		So(string(b), ShouldContainSubstring, "[a] Started a: Enabled service")
+	})
+}
+
+func TestJournal(t *testing.T) {
+	Convey("Test log journals", t, func() {
+		dir, e := ioutil.TempDir("", "govisor")
+		So(e, ShouldBeNil)
+		Reset(func() {
+			os.RemoveAll(dir)
+		})
+		j, e := OpenJournal(dir, JournalRetention{})
+		So(e, ShouldBeNil)
+		Reset(func() {
+			j.Close()
+		})
+		base := time.Now()
+		for i := 1; i <= 100; i++ {
+			So(j.Append(LogRecord{
+				Id:   int64(i * 10),
+				Time: base.Add(time.Duration(i) * time.Second),
+				Text: "line",
+			}), ShouldBeNil)
+		}
+		So(j.Append(LogRecord{Id: 1000}), ShouldEqual, ErrBadRecordId)
+		So(j.FirstId(), ShouldEqual, 10)
+		So(j.LastId(), ShouldEqual, 1000)
+
+		recs, e := j.Read(495, 3)
+		So(e, ShouldBeNil)
+		So(len(recs), ShouldEqual, 3)
+		So(recs[0].Id, ShouldEqual, 500)
+		So(recs[2].Id, ShouldEqual, 520)
+		So(recs[0].Text, ShouldEqual, "line")
+
+		recs, e = j.ReadTime(base.Add(time.Second*90), 0)
+		So(e, ShouldBeNil)
+		So(len(recs), ShouldEqual, 11)
+		So(recs[0].Id, ShouldEqual, 900)
+
+		recs, e = j.Tail(5)
+		So(e, ShouldBeNil)
+		So(len(recs), ShouldEqual, 5)
+		So(recs[0].Id, ShouldEqual, 960)
+
+		Convey("Records survive reopening, less a partial one", func() {
+			So(j.Close(), ShouldBeNil)
+			names, _ := filepath.Glob(filepath.Join(dir, "*.log"))
+			So(len(names), ShouldEqual, 1)
+			f, e := os.OpenFile(names[0], os.O_WRONLY|os.O_APPEND, 0)
+			So(e, ShouldBeNil)
+			f.WriteString(`{"id":"1010","ti`)
+			f.Close()
+
+			j, e = OpenJournal(dir, JournalRetention{})
+			So(e, ShouldBeNil)
+			So(j.LastId(), ShouldEqual, 1000)
+			So(j.Append(LogRecord{Id: 1010, Text: "more"}), ShouldBeNil)
+			recs, e := j.Read(990, 0)
+			So(e, ShouldBeNil)
+			So(len(recs), ShouldEqual, 2)
+			So(recs[1].Text, ShouldEqual, "more")
+		})
+
+		Convey("Old segments are removed", func() {
+			j2, e := OpenJournal(filepath.Join(dir, "small"),
+				JournalRetention{MaxSize: 2000})
+			So(e, ShouldBeNil)
+			for i := 1; i <= 100; i++ {
+				So(j2.Append(LogRecord{
+					Id:   int64(i),
+					Time: time.Now(),
+					Text: "line",
+				}), ShouldBeNil)
+			}
+			So(j2.FirstId(), ShouldBeGreaterThan, 50)
+			So(j2.LastId(), ShouldEqual, 100)
+			recs, e := j2.Read(0, 0)
+			So(e, ShouldBeNil)
+			So(recs[0].Id, ShouldEqual, j2.FirstId())
+			So(recs[len(recs)-1].Id, ShouldEqual, 100)
+			So(j2.Close(), ShouldBeNil)
+		})
+	})
+
+	Convey("Test manager and service journals", t, func() {
+		dir, e := ioutil.TempDir("", "govisor")
+		So(e, ShouldBeNil)
+		Reset(func() {
+			os.RemoveAll(dir)
+		})
+		m := NewManager("TestJournal")
+		SetTestLogger(t, m)
+		So(m.SetJournal(dir, JournalRetention{}), ShouldBeNil)
+		s := NewService(&testS{name: "a:1"})
+		So(m.AddService(s), ShouldBeNil)
+		So(s.Enable(), ShouldBeNil)
+		m.Shutdown()
+		_, e = os.Stat(filepath.Join(dir, "services", "a%3A1"))
+		So(e, ShouldBeNil)
+
+		// Names map to directories one to one.
+		names := make(map[string]bool)
+		for _, n := range []string{"a:b", "a@b", "a/b", "a\\b", "a_b",
+			"a%3Ab", ".", "..", "%2E"} {
+			jn := journalName(n)
+			So(names[jn], ShouldBeFalse)
+			So(jn, ShouldNotContainSubstring, "/")
+			So(jn, ShouldNotEqual, "..")
+			names[jn] = true
+		}
+
+		m = NewManager("TestJournal")
+		SetTestLogger(t, m)
+		Reset(func() {
+			m.Shutdown()
+		})
+		So(m.SetJournal(dir, JournalRetention{}), ShouldBeNil)
+		s = NewService(&testS{name: "a:1"})
+		So(m.AddService(s), ShouldBeNil)
+
+		recs, _ := s.GetLog(0)
+		So(len(recs), ShouldBeGreaterThan, 2)
+		So(recs[0].Service, ShouldEqual, "a:1")
+		So(recs[0].Text, ShouldStartWith, "Added service")
+		found := false
+		for _, r := range recs {
+			found = found || strings.HasPrefix(r.Text, "Started a:1")
+		}
+		So(found, ShouldBeTrue)
+		for i := 1; i < len(recs); i++ {
+			So(recs[i].Id, ShouldBeGreaterThan, recs[i-1].Id)
+		}
+
+		recs, _ = m.GetLog(0)
+		found = false
+		for _, r := range recs {
+			found = found || strings.Contains(r.Text, "Govisor shut down")
+		}
+		So(found, ShouldBeTrue)
+	})
+}
+
+func TestLogReadRecords(t *testing.T) {
+	Convey("Test reading records since an ID", t, func() {
+		l := NewLog()
+		l.maxRecords = 10
+		for i := 0; i < 25; i++ {
+			l.Write([]byte("line\n"))
+		}
+		recs, id, lost := l.ReadRecords(0, 0)
+		So(len(recs), ShouldEqual, 10)
+		So(lost, ShouldBeFalse)
+		So(recs[9].Id, ShouldEqual, id)
+
+		recs, _, lost = l.ReadRecords(id-5, 0)
+		So(len(recs), ShouldEqual, 5)
+		So(recs[0].Id, ShouldEqual, id-4)
+		So(lost, ShouldBeFalse)
+
+		// A short read gives the ID to continue from.
+		recs, id2, lost := l.ReadRecords(id-5, 2)
+		So(len(recs), ShouldEqual, 2)
+		So(recs[1].Id, ShouldEqual, id-3)
+		So(id2, ShouldEqual, id-3)
+
+		recs, _, lost = l.ReadRecords(id, 0)
+		So(len(recs), ShouldEqual, 0)
+		So(lost, ShouldBeFalse)
+
+		// The oldest that is kept follows id-10, which was dropped.
+		recs, _, lost = l.ReadRecords(id-10, 0)
+		So(len(recs), ShouldEqual, 10)
+		So(lost, ShouldBeFalse)
+		recs, _, lost = l.ReadRecords(id-11, 0)
+		So(len(recs), ShouldEqual, 10)
+		So(recs[0].Id, ShouldEqual, id-9)
+		So(lost, ShouldBeTrue)
+
+		// So is anything from before the log started.
+		_, _, lost = l.ReadRecords(1, 0)
+		So(lost, ShouldBeTrue)
+
+		recs, id2 = l.GetRecords(id - 3)
+		So(len(recs), ShouldEqual, 3)
+		So(id2, ShouldEqual, id)
+
+		Convey("Older records are read from the journal", func() {
+			dir, e := ioutil.TempDir("", "govisor")
+			So(e, ShouldBeNil)
+			Reset(func() {
+				os.RemoveAll(dir)
+			})
+			j, e := OpenJournal(dir, JournalRetention{})
+			So(e, ShouldBeNil)
+			Reset(func() {
+				j.Close()
+			})
+			l := NewLog()
+			l.maxRecords = 10
+			l.SetJournal(j)
+			for i := 0; i < 25; i++ {
+				l.Write([]byte("line\n"))
+			}
+			recs, id, lost := l.ReadRecords(0, 0)
+			So(len(recs), ShouldEqual, 10)
+			recs, _, lost = l.ReadRecords(id-20, 0)
+			So(len(recs), ShouldEqual, 20)
+			So(recs[0].Id, ShouldEqual, id-19)
+			So(lost, ShouldBeFalse)
+			recs, id2, lost := l.ReadRecords(id-20, 5)
+			So(len(recs), ShouldEqual, 5)
+			So(recs[4].Id, ShouldEqual, id-15)
+			So(id2, ShouldEqual, id-15)
+
+			recs, _ = l.GetRecords(id - 20)
+			So(len(recs), ShouldEqual, 20)
+			So(recs[0].Id, ShouldEqual, id-19)
+
+			// Reads of the journal are bounded.
+			for i := 0; i < MaxLogRecords; i++ {
+				l.Write([]byte("line\n"))
+			}
+			recs, id2, _ = l.ReadRecords(id-20, 0)
+			So(len(recs), ShouldEqual, MaxLogRecords)
+			So(id2, ShouldEqual, recs[MaxLogRecords-1].Id)
+			recs, id2, _ = l.ReadRecords(id2, 0)
+			So(len(recs), ShouldEqual, 20)
+			So(id2, ShouldEqual, id+MaxLogRecords)
+		})
+	})
+}
+
+func TestLogSearch(t *testing.T) {
+	Convey("Test searching a log", t, func() {
+		l := NewLog()
+		l.maxRecords = 10
+		start := time.Now()
+		for i := 0; i < 25; i++ {
+			stream := StreamStdout
+			if i%5 == 0 {
+				stream = StreamStderr
+			}
+			l.Record(LogRecord{
+				Time:   start.Add(time.Duration(i) * time.Second),
+				Stream: stream,
+				Text:   fmt.Sprintf("line %d", i),
+			})
+		}
+		recs, id := l.Search(LogQuery{Match: "line 2"})
+		So(id, ShouldEqual, l.id)
+		// Only the records in memory, 15 to 24, are searched.
+		So(len(recs), ShouldEqual, 5)
+		So(recs[0].Text, ShouldEqual, "line 20")
+
+		recs, _ = l.Search(LogQuery{Regexp: regexp.MustCompile(`^line 1[78]$`)})
+		So(len(recs), ShouldEqual, 2)
+		So(recs[1].Text, ShouldEqual, "line 18")
+
+		recs, _ = l.Search(LogQuery{Streams: []string{StreamStderr}})
+		So(len(recs), ShouldEqual, 2)
+		So(recs[0].Text, ShouldEqual, "line 15")
+
+		recs, _ = l.Search(LogQuery{
+			From: start.Add(17 * time.Second),
+			To:   start.Add(19 * time.Second),
+		})
+		So(len(recs), ShouldEqual, 3)
+		So(recs[0].Text, ShouldEqual, "line 17")
+		So(recs[2].Text, ShouldEqual, "line 19")
+
+		recs, _ = l.Search(LogQuery{Tail: 3})
+		So(len(recs), ShouldEqual, 3)
+		So(recs[2].Text, ShouldEqual, "line 24")
+
+		recs, id = l.Search(LogQuery{Limit: 2})
+		So(len(recs), ShouldEqual, 2)
+		So(recs[0].Text, ShouldEqual, "line 15")
+		So(id, ShouldEqual, recs[1].Id)
+
+		recs, _ = l.Search(LogQuery{Level: LevelWarning})
+		So(len(recs), ShouldEqual, 0)
+
+		Convey("With a journal, all of it is searched", func() {
+			dir, e := ioutil.TempDir("", "govisor")
+			So(e, ShouldBeNil)
+			Reset(func() {
+				os.RemoveAll(dir)
+			})
+			j, e := OpenJournal(dir, JournalRetention{})
+			So(e, ShouldBeNil)
+			Reset(func() {
+				j.Close()
+			})
+			l := NewLog()
+			l.maxRecords = 10
+			l.SetJournal(j)
+			for i := 0; i < 25; i++ {
+				l.Record(LogRecord{
+					Time: start.Add(time.Duration(i) * time.Second),
+					Text: fmt.Sprintf("line %d", i),
+				})
+			}
+			recs, _ := l.Search(LogQuery{Match: "line 1"})
+			So(len(recs), ShouldEqual, 11)
+			So(recs[0].Text, ShouldEqual, "line 1")
+
+			recs, _ = l.Search(LogQuery{Match: "line 1", Tail: 4})
+			So(len(recs), ShouldEqual, 4)
+			So(recs[0].Text, ShouldEqual, "line 16")
+
+			recs, _ = l.Search(LogQuery{
+				From: start.Add(3 * time.Second),
+				To:   start.Add(5 * time.Second),
+			})
+			So(len(recs), ShouldEqual, 3)
+			So(recs[0].Text, ShouldEqual, "line 3")
+
+			recs, _ = l.Search(LogQuery{Since: recs[0].Id, Limit: 1})
+			So(len(recs), ShouldEqual, 1)
+			So(recs[0].Text, ShouldEqual, "line 4")
+
+			recs, _ = l.Search(LogQuery{Tail: 12})
+			So(len(recs), ShouldEqual, 12)
+			So(recs[0].Text, ShouldEqual, "line 13")
+		})
+	})
+
+	Convey("Test matching levels and streams", t, func() {
+		So(rest.LevelAtLeast(LevelError, LevelWarning), ShouldBeTrue)
+		So(rest.LevelAtLeast(LevelInfo, LevelWarning), ShouldBeFalse)
+		So(rest.LevelAtLeast("", LevelInfo), ShouldBeFalse)
+		So(rest.LevelAtLeast("", ""), ShouldBeTrue)
+		So(rest.MatchStream(StreamStderr, nil), ShouldBeTrue)
+		So(rest.MatchStream(StreamStderr, []string{StreamStdout}),
+			ShouldBeFalse)
+		So(rest.MatchStream(StreamStderr,
+			[]string{StreamStdout, StreamStderr}), ShouldBeTrue)
+	})
+
+}
@@ -24,8 +24,42 @@
 //	-p <passwd>	- use Basic Auth with a password of user:bcrypt
 //			  pairs.  Bcrypt is an encrypted password.
 //	-g <user:pass>	- generate & use encrypted password & user
-//	-e <bool>	- enable/disable (true/false) all services (true)
-//	-n <name>	- name this instance, e.g. for Realm, etc.
+//	-e <bool>	- enable/disable (true/false) all services (true),
+//			  except those with saved state
+//	-statefile <file> - where service state is saved, empty to
+//			  disable (default is $GOVISORDIR/<name>/state.json)
+//	-n <name>	- name this instance, e.g. for Realm, etc.  Each
+//			  instance should have its own name, as its state
+//			  and logs are kept under it by default
+//	-watch		- watch the services directory, and reload
+//			  automatically when it changes (Linux only)
+//	-subreaper	- adopt and reap the orphaned descendants of
+//			  services (Linux only)
+//	-logdir <dir>	- where service log files (see the logFile
+//			  manifest field) live, default is under $GOVISORDIR
+//	-journal <dir>	- keep the logs of the manager and services in
+//			  journals on disk under this directory, so that
+//			  they survive restarts
+//	-journalsize <n> - remove the oldest records of each journal once
+//			  it exceeds this many bytes
+//	-journalage <d>	- remove records once they are this old, e.g. 168h
+//	-check		- check the manifests for errors, and exit without
+//			  starting anything; exits non-zero if any are found
+//
+// Manifests may be written in JSON, YAML (.yaml or .yml) or TOML (.toml);
+// the file extension selects the format.  A manifest named like
+// "worker@.json" is a template, and each of its instances is a separate
+// service; see govisor.Manager.Instantiate.
+//
+// Sending SIGHUP causes the services directory to be rescanned.  New
+// manifests are added, removed ones are deleted, and services whose
+// manifests changed are restarted.  Other services are left alone.
+//
+// If GOVISORDIR is not set in the environment, then it is /var/govisor for
+// root, and $HOME/.govisor for other users.
+//
+// Sending SIGUSR1 causes the log file, and the log files of services, to
+// be reopened, for use with external log rotation.
 //
 package main
 
@@ -120,14 +154,22 @@ func die(format string, v ...interface{}) {
 }
 
 func main() {
+	govisor.RunLauncher()
+
 	dir := "."
 	name := "govisord"
 	enable := true
+	watch := false
+	check := false
+	subreaper := false
+	logDir := ""
+	journalDir := ""
+	var journalRet govisor.JournalRetention
 	passFile := ""
 	genpass := ""
 	certFile := ""
 	keyFile := ""
-	m := govisor.NewManager(name)
+	stateFile := ""
 
 	flag.StringVar(&certFile, "certfile", certFile, "certificate file (for TLS)")
 	flag.StringVar(&keyFile, "keyfile", keyFile, "key file (for TLS)")
@@ -135,15 +177,53 @@ func main() {
 	flag.StringVar(&dir, "dir", dir, "configuration directory")
 	flag.StringVar(&name, "name", name, "govisor name")
 	flag.BoolVar(&enable, "enable", enable, "enable all services")
+	flag.BoolVar(&watch, "watch", watch, "reload when manifests change")
+	flag.BoolVar(&check, "check", check, "check manifests and exit")
+	flag.BoolVar(&subreaper, "subreaper", subreaper, "reap orphaned processes")
 	flag.StringVar(&passFile, "passfile", passFile, "password file")
 	flag.StringVar(&genpass, "passwd", genpass, "generate password")
 	flag.StringVar(&logFile, "logfile", logFile, "log file")
+	flag.StringVar(&logDir, "logdir", logDir, "service log directory")
+	flag.StringVar(&journalDir, "journal", journalDir, "journal directory")
+	flag.Int64Var(&journalRet.MaxSize, "journalsize", 0,
+		"maximum size of each journal")
+	flag.DurationVar(&journalRet.MaxAge, "journalage", 0,
+		"maximum age of journal records")
+	flag.StringVar(&stateFile, "statefile", stateFile,
+		"state file (default $GOVISORDIR/<name>/state.json)")
 	flag.Parse()
 
-	var lf *os.File
+	m := govisor.NewManager(name)
+	// The default depends upon the name, so that instances with their
+	// own names, and so their own directories, keep their own state.
+	stateSet := false
+	flag.Visit(func(f *flag.Flag) {
+		stateSet = stateSet || f.Name == "statefile"
+	})
+	if !stateSet {
+		stateFile = m.DefaultStateFile()
+	}
+
+	if check {
+		errs := govisor.CheckManifests(path.Join(dir, "services"))
+		for _, e := range errs {
+			fmt.Println(e)
+		}
+		if len(errs) != 0 {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if subreaper {
+		if e := govisor.SetSubreaper(); e != nil {
+			die("Failed to become subreaper: %v", e)
+		}
+	}
+
+	var lf *govisor.LogFile
 	var e error
 	if logFile != "" {
-		lf, e = os.OpenFile(logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		lf, e = govisor.OpenLogFile(logFile, govisor.LogRotation{})
 		if e != nil {
 			die("Failed to open log file: %v", e)
 		}
@@ -153,7 +233,13 @@ func main() {
 
 	sigs := make(chan os.Signal, 1)
 	done := make(chan bool, 1)
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	hups := make(chan os.Signal, 1)
+	signal.Notify(hups, syscall.SIGHUP)
+	usr1s := make(chan os.Signal, 1)
+	if len(reopenSignals) != 0 {
+		signal.Notify(usr1s, reopenSignals...)
+	}
 
 	h := &MyHandler{
 		h:      server.NewHandler(m),
@@ -210,32 +296,28 @@ func main() {
 	time.Sleep(time.Millisecond * 100)
 
 	svcDir := path.Join(dir, "services")
-	if d, e := os.Open(svcDir); e != nil {
+	if _, e := os.Stat(svcDir); e != nil {
 		die("Failed to open services directory %s: %v", svcDir, e)
-	} else if files, e := d.Readdirnames(-1); e != nil {
-		die("Failed to scan scan services: %v", e)
-	} else {
-		for _, f := range files {
-			fname := path.Join(svcDir, f)
-			if mf, e := os.Open(fname); e != nil {
-				log.Printf("Failed to open manifest %s: %v",
-					fname, e)
-			} else if p, e := govisor.NewProcessFromJson(mf); e != nil {
-				log.Printf("Failed to load manifest %s: %v",
-					fname, e)
-				mf.Close()
-			} else if e := m.AddService(p); e != nil {
-				/* Failure logged by m already */
-				mf.Close()
-			}
+	}
+	if logDir != "" {
+		m.SetLogDir(logDir)
+	}
+	if journalDir != "" {
+		if e := m.SetJournal(journalDir, journalRet); e != nil {
+			die("Failed to open journal in %s: %v", journalDir, e)
 		}
 	}
+	// Failures are logged by m already
+	m.LoadServices(svcDir)
 
+	m.SetStateFile(stateFile)
+	if e := m.RestoreState(enable); e != nil {
+		log.Printf("Failed to restore state: %v", e)
+	}
 	m.StartMonitoring()
-	if enable {
-		svcs, _, _ := m.Services()
-		for _, s := range svcs {
-			s.Enable()
+	if watch {
+		if e := m.StartAutoReload(); e != nil {
+			die("Failed to watch %s: %v", svcDir, e)
 		}
 	}
 
@@ -245,6 +327,23 @@ func main() {
 		done <- true
 	}()
 
+	// SIGHUP rescans the manifests.
+	go func() {
+		for range hups {
+			m.Reload()
+		}
+	}()
+
+	// SIGUSR1 reopens the log files.
+	go func() {
+		for range usr1s {
+			if lf != nil {
+				lf.Reopen()
+			}
+			m.ReopenLogs()
+		}
+	}()
+
 	// Wait for a termination signal, and shutdown cleanly if we get it.
 	<-done
 	m.Shutdown()
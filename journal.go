@@ -0,0 +1,645 @@
+// Copyright 2016 The Govisor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package govisor
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JournalSegmentSize is the size at which a journal starts a new segment.
+// Journals with a smaller MaxSize use segments of a quarter of that size,
+// so that retention is not too coarse.
+const JournalSegmentSize = 4 << 20
+
+// JournalRetention describes how much of a journal is kept.  Whole
+// segments are removed, oldest first, while the journal is larger than
+// MaxSize, or while the newest record in the segment is older than MaxAge.
+// The segment being written is always kept.  Zero values keep everything.
+type JournalRetention struct {
+	MaxSize int64         `json:"maxSize"` // Bytes
+	MaxAge  time.Duration `json:"maxAge"`
+}
+
+// Journal is an append-only store of log records on disk.  It is a
+// directory of segments, each named for the hexadecimal ID of its first
+// record.  The records in a segment are kept in a .log file, one JSON
+// object per line, and the .idx file beside it has the ID, time and
+// offset of each record, so that records can be found by ID or time
+// without reading the records before them.  Record IDs must increase,
+// and times are assumed to.
+//
+// If the daemon dies while writing, the partial record is discarded when
+// the journal is next opened.
+type Journal struct {
+	dir    string
+	ret    JournalRetention
+	segs   []*segment
+	data   *os.File // Records of the last segment
+	index  *os.File // Index of the last segment
+	last   int64    // ID of the last record
+	pruned time.Time
+	closed bool
+	mx     sync.Mutex
+}
+
+type segment struct {
+	first int64     // ID of the first record, which names the files
+	last  int64     // ID of the last record
+	start time.Time // Time of the first record
+	end   time.Time // Time of the last record
+	count int64     // Records
+	size  int64     // Bytes of records
+}
+
+// indexEntry is the size of an entry in the index, which holds the ID,
+// the time in nanoseconds, and the offset of the record, each as a big
+// endian 64-bit value.
+const indexEntry = 24
+
+// OpenJournal opens the journal in dir, creating it if need be.
+func OpenJournal(dir string, ret JournalRetention) (*Journal, error) {
+	if e := os.MkdirAll(dir, 0755); e != nil {
+		return nil, e
+	}
+	names, e := filepath.Glob(filepath.Join(dir, "*.log"))
+	if e != nil {
+		return nil, e
+	}
+	j := &Journal{dir: dir, ret: ret}
+	for _, name := range names {
+		base := strings.TrimSuffix(filepath.Base(name), ".log")
+		first, e := strconv.ParseInt(base, 16, 64)
+		if e != nil || len(base) != 16 {
+			continue
+		}
+		j.segs = append(j.segs, &segment{first: first})
+	}
+	sort.Slice(j.segs, func(a, b int) bool {
+		return j.segs[a].first < j.segs[b].first
+	})
+	segs := j.segs[:0]
+	for i, seg := range j.segs {
+		// The last segment may have been cut short, so we check it
+		// properly.  The others just need an index.
+		if i == len(j.segs)-1 || j.load(seg) != nil {
+			if e := j.rebuild(seg); e != nil {
+				return nil, e
+			}
+		}
+		if seg.count == 0 && i != len(j.segs)-1 {
+			os.Remove(j.path(seg, ".log"))
+			os.Remove(j.path(seg, ".idx"))
+			continue
+		}
+		segs = append(segs, seg)
+		if seg.count != 0 {
+			j.last = seg.last
+		}
+	}
+	j.segs = segs
+	if len(j.segs) != 0 {
+		if e := j.openSegment(j.segs[len(j.segs)-1]); e != nil {
+			return nil, e
+		}
+	}
+	j.prune()
+	return j, nil
+}
+
+func (j *Journal) path(seg *segment, ext string) string {
+	return filepath.Join(j.dir, fmt.Sprintf("%016x%s", seg.first, ext))
+}
+
+// load reads the extent of a segment from its index.
+func (j *Journal) load(seg *segment) error {
+	info, e := os.Stat(j.path(seg, ".log"))
+	if e != nil {
+		return e
+	}
+	seg.size = info.Size()
+	f, e := os.Open(j.path(seg, ".idx"))
+	if e != nil {
+		return e
+	}
+	defer f.Close()
+	if info, e = f.Stat(); e != nil {
+		return e
+	}
+	if info.Size()%indexEntry != 0 {
+		return fmt.Errorf("Bad journal index %s", f.Name())
+	}
+	seg.count = info.Size() / indexEntry
+	if seg.count == 0 {
+		return nil
+	}
+	id, t, _, e := readEntry(f, 0)
+	if e != nil {
+		return e
+	}
+	seg.start = t
+	if id != seg.first {
+		return fmt.Errorf("Bad journal index %s", f.Name())
+	}
+	id, t, off, e := readEntry(f, seg.count-1)
+	if e != nil {
+		return e
+	}
+	if off >= seg.size {
+		return fmt.Errorf("Bad journal index %s", f.Name())
+	}
+	seg.last = id
+	seg.end = t
+	return nil
+}
+
+// rebuild recreates the index of a segment from its records, discarding
+// anything after the last whole record.
+func (j *Journal) rebuild(seg *segment) error {
+	f, e := os.OpenFile(j.path(seg, ".log"), os.O_RDWR, 0644)
+	if e != nil {
+		return e
+	}
+	defer f.Close()
+	var index []byte
+	var off int64
+	seg.count = 0
+	r := bufio.NewReader(f)
+	for {
+		line, e := r.ReadBytes('\n')
+		if e != nil {
+			break
+		}
+		var rec LogRecord
+		if json.Unmarshal(line, &rec) != nil {
+			break
+		}
+		index = appendEntry(index, rec, off)
+		if seg.count == 0 {
+			seg.start = rec.Time
+		}
+		seg.last = rec.Id
+		seg.end = rec.Time
+		seg.count++
+		off += int64(len(line))
+	}
+	if e := f.Truncate(off); e != nil {
+		return e
+	}
+	seg.size = off
+	return writeFileAtomic(j.path(seg, ".idx"), index)
+}
+
+func appendEntry(b []byte, r LogRecord, off int64) []byte {
+	var ent [indexEntry]byte
+	binary.BigEndian.PutUint64(ent[0:], uint64(r.Id))
+	binary.BigEndian.PutUint64(ent[8:], uint64(r.Time.UnixNano()))
+	binary.BigEndian.PutUint64(ent[16:], uint64(off))
+	return append(b, ent[:]...)
+}
+
+func readEntry(f io.ReaderAt, n int64) (int64, time.Time, int64, error) {
+	var ent [indexEntry]byte
+	if _, e := f.ReadAt(ent[:], n*indexEntry); e != nil {
+		return 0, time.Time{}, 0, e
+	}
+	id := int64(binary.BigEndian.Uint64(ent[0:]))
+	t := time.Unix(0, int64(binary.BigEndian.Uint64(ent[8:])))
+	off := int64(binary.BigEndian.Uint64(ent[16:]))
+	return id, t, off, nil
+}
+
+// openSegment opens a segment for appending, making it the last one.
+func (j *Journal) openSegment(seg *segment) error {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	data, e := os.OpenFile(j.path(seg, ".log"), flags, 0644)
+	if e != nil {
+		return e
+	}
+	index, e := os.OpenFile(j.path(seg, ".idx"), flags, 0644)
+	if e != nil {
+		data.Close()
+		return e
+	}
+	j.data = data
+	j.index = index
+	return nil
+}
+
+func (j *Journal) closeSegment() {
+	if j.data != nil {
+		j.data.Close()
+		j.index.Close()
+		j.data = nil
+		j.index = nil
+	}
+}
+
+func (j *Journal) segmentSize() int64 {
+	size := int64(JournalSegmentSize)
+	if j.ret.MaxSize > 0 && j.ret.MaxSize/4 < size {
+		size = j.ret.MaxSize / 4
+	}
+	return size
+}
+
+// Append adds a record to the journal.  Its ID must be greater than that
+// of the last record.
+func (j *Journal) Append(r LogRecord) error {
+	j.mx.Lock()
+	defer j.mx.Unlock()
+	if j.closed {
+		return os.ErrClosed
+	}
+	if r.Id <= j.last {
+		return ErrBadRecordId
+	}
+	b, e := json.Marshal(r)
+	if e != nil {
+		return e
+	}
+	b = append(b, '\n')
+	var seg *segment
+	if len(j.segs) != 0 {
+		seg = j.segs[len(j.segs)-1]
+	}
+	if seg == nil || (seg.size > 0 && seg.size+int64(len(b)) > j.segmentSize()) {
+		j.closeSegment()
+		seg = &segment{first: r.Id}
+		if e := j.openSegment(seg); e != nil {
+			return e
+		}
+		j.segs = append(j.segs, seg)
+		j.prune()
+	}
+	if _, e := j.data.Write(b); e != nil {
+		return e
+	}
+	if _, e := j.index.Write(appendEntry(nil, r, seg.size)); e != nil {
+		return e
+	}
+	if seg.count == 0 {
+		seg.start = r.Time
+	}
+	seg.last = r.Id
+	seg.end = r.Time
+	seg.count++
+	seg.size += int64(len(b))
+	j.last = r.Id
+	if j.ret.MaxAge > 0 && time.Since(j.pruned) > time.Minute {
+		j.prune()
+	}
+	return nil
+}
+
+// prune removes the segments that are not to be kept.
+func (j *Journal) prune() {
+	j.pruned = time.Now()
+	var total int64
+	for _, seg := range j.segs {
+		total += seg.size
+	}
+	for len(j.segs) > 1 {
+		seg := j.segs[0]
+		if !(j.ret.MaxSize > 0 && total > j.ret.MaxSize) &&
+			!(j.ret.MaxAge > 0 && time.Since(seg.end) > j.ret.MaxAge) {
+			break
+		}
+		os.Remove(j.path(seg, ".log"))
+		os.Remove(j.path(seg, ".idx"))
+		total -= seg.size
+		j.segs = j.segs[1:]
+	}
+}
+
+// full returns the number of segments with records in them.  Only the
+// last segment can be empty.
+func (j *Journal) full() int {
+	n := len(j.segs)
+	if n != 0 && j.segs[n-1].count == 0 {
+		n--
+	}
+	return n
+}
+
+// snapshot returns copies of the segments that have records, so that
+// they can be read without holding the lock while records are appended.
+func (j *Journal) snapshot() []segment {
+	j.mx.Lock()
+	defer j.mx.Unlock()
+	segs := make([]segment, 0, len(j.segs))
+	for _, seg := range j.segs[:j.full()] {
+		segs = append(segs, *seg)
+	}
+	return segs
+}
+
+// search returns the first record in a segment for which f is true, or
+// the number of records if there is none.
+func (j *Journal) search(seg *segment,
+	f func(int64, time.Time) bool) (int64, error) {
+	idx, e := os.Open(j.path(seg, ".idx"))
+	if e != nil {
+		return 0, e
+	}
+	defer idx.Close()
+	var err error
+	n := sort.Search(int(seg.count), func(k int) bool {
+		id, t, _, e := readEntry(idx, int64(k))
+		if e != nil {
+			err = e
+			return true
+		}
+		return f(id, t)
+	})
+	return int64(n), err
+}
+
+// seekId returns the segment and record of the first record with an ID
+// greater than since.
+func (j *Journal) seekId(segs []segment, since int64) (int, int64, error) {
+	si := sort.Search(len(segs), func(i int) bool {
+		return segs[i].last > since
+	})
+	if si == len(segs) {
+		return si, 0, nil
+	}
+	k, e := j.search(&segs[si], func(id int64, _ time.Time) bool {
+		return id > since
+	})
+	return si, k, e
+}
+
+// seekTime returns the segment and record of the first record from time
+// t onwards.
+func (j *Journal) seekTime(segs []segment, t time.Time) (int, int64, error) {
+	si := sort.Search(len(segs), func(i int) bool {
+		return !segs[i].end.Before(t)
+	})
+	if si == len(segs) {
+		return si, 0, nil
+	}
+	k, e := j.search(&segs[si], func(_ int64, rt time.Time) bool {
+		return !rt.Before(t)
+	})
+	return si, k, e
+}
+
+// scan calls fn for each record, starting with record k of segment si,
+// until fn returns false.  Segments removed by pruning in the meantime
+// are skipped.
+func (j *Journal) scan(segs []segment, si int, k int64,
+	fn func(LogRecord) bool) error {
+	for ; si < len(segs); si, k = si+1, 0 {
+		seg := &segs[si]
+		if k >= seg.count {
+			continue
+		}
+		var off int64
+		if k > 0 {
+			idx, e := os.Open(j.path(seg, ".idx"))
+			if os.IsNotExist(e) {
+				continue
+			} else if e != nil {
+				return e
+			}
+			_, _, off, e = readEntry(idx, k)
+			idx.Close()
+			if e != nil {
+				return e
+			}
+		}
+		f, e := os.Open(j.path(seg, ".log"))
+		if os.IsNotExist(e) {
+			continue
+		} else if e != nil {
+			return e
+		}
+		r := bufio.NewReader(io.NewSectionReader(f, off, seg.size-off))
+		for {
+			line, e := r.ReadBytes('\n')
+			if e != nil {
+				break
+			}
+			var rec LogRecord
+			if e := json.Unmarshal(line, &rec); e != nil {
+				f.Close()
+				return e
+			}
+			if !fn(rec) {
+				f.Close()
+				return nil
+			}
+		}
+		f.Close()
+	}
+	return nil
+}
+
+// read returns up to n records, or all of them if n <= 0, starting with
+// record k of segment si.
+func (j *Journal) read(segs []segment, si int, k int64,
+	n int) ([]LogRecord, error) {
+	var recs []LogRecord
+	e := j.scan(segs, si, k, func(r LogRecord) bool {
+		recs = append(recs, r)
+		return n <= 0 || len(recs) < n
+	})
+	return recs, e
+}
+
+// Read returns up to n records with IDs greater than since, or all of
+// them if n <= 0.
+func (j *Journal) Read(since int64, n int) ([]LogRecord, error) {
+	segs := j.snapshot()
+	si, k, e := j.seekId(segs, since)
+	if e != nil {
+		return nil, e
+	}
+	return j.read(segs, si, k, n)
+}
+
+// ReadTime returns up to n records from time t onwards, or all of them if
+// n <= 0.
+func (j *Journal) ReadTime(t time.Time, n int) ([]LogRecord, error) {
+	segs := j.snapshot()
+	si, k, e := j.seekTime(segs, t)
+	if e != nil {
+		return nil, e
+	}
+	return j.read(segs, si, k, n)
+}
+
+// Scan calls fn for each record with an ID greater than since, from time
+// t onwards, in order, until fn returns false.  A zero t means from the
+// oldest record.  Records appended while scanning are not seen.
+func (j *Journal) Scan(since int64, t time.Time, fn func(LogRecord) bool) error {
+	segs := j.snapshot()
+	si, k, e := j.seekId(segs, since)
+	if e == nil && !t.IsZero() {
+		var tsi int
+		var tk int64
+		if tsi, tk, e = j.seekTime(segs, t); tsi > si ||
+			(tsi == si && tk > k) {
+			si, k = tsi, tk
+		}
+	}
+	if e != nil {
+		return e
+	}
+	return j.scan(segs, si, k, fn)
+}
+
+// Tail returns the last n records.
+func (j *Journal) Tail(n int) ([]LogRecord, error) {
+	segs := j.snapshot()
+	si := len(segs) - 1
+	if si < 0 || n <= 0 {
+		return nil, nil
+	}
+	need := int64(n)
+	for si > 0 && segs[si].count < need {
+		need -= segs[si].count
+		si--
+	}
+	k := segs[si].count - need
+	if k < 0 {
+		k = 0
+	}
+	return j.read(segs, si, k, 0)
+}
+
+// FirstId returns the ID of the oldest record kept, or zero if the
+// journal is empty.
+func (j *Journal) FirstId() int64 {
+	j.mx.Lock()
+	defer j.mx.Unlock()
+	if j.full() == 0 {
+		return 0
+	}
+	return j.segs[0].first
+}
+
+// LastId returns the ID of the newest record, or zero if the journal has
+// never had one.
+func (j *Journal) LastId() int64 {
+	j.mx.Lock()
+	defer j.mx.Unlock()
+	return j.last
+}
+
+// Close closes the journal.
+func (j *Journal) Close() error {
+	j.mx.Lock()
+	defer j.mx.Unlock()
+	j.closed = true
+	j.closeSegment()
+	return nil
+}
+
+// journalName returns the name of the directory for the journal of a
+// service, which may not contain a path separator, or a colon on some
+// platforms.  These are percent encoded, as is the percent sign itself, so
+// that different services never share a journal.  So are names that are
+// nothing but dots.
+func journalName(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		switch c := name[i]; c {
+		case '%', '/', '\\', ':':
+			fmt.Fprintf(&b, "%%%02X", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	if strings.Trim(name, ".") == "" {
+		return strings.Repeat("%2E", len(name))
+	}
+	return b.String()
+}
+
+// SetJournal keeps the logs of the manager and its services in journals
+// under dir, so that they survive restarts, and are not limited to the
+// records held in memory.  The manager's journal is in the directory
+// "manager", and each service's in "services/<name>", with any path
+// separators, colons and percent signs in the name percent encoded.  An
+// empty dir stops keeping journals.  If the manager's journal cannot be
+// opened, then no journals are kept.
+func (m *Manager) SetJournal(dir string, ret JournalRetention) error {
+	m.lock()
+	defer m.unlock()
+	m.closeJournal()
+	var e error
+	if dir != "" {
+		var j *Journal
+		if j, e = OpenJournal(filepath.Join(dir, "manager"), ret); e != nil {
+			dir = ""
+		} else {
+			m.journal = j
+			m.log.SetJournal(j)
+		}
+	}
+	m.journalDir = dir
+	m.journalRet = ret
+	for s := range m.services {
+		s.openJournal()
+	}
+	return e
+}
+
+// closeJournal closes the manager's journal.  Call with the lock held.
+func (m *Manager) closeJournal() {
+	if m.journal != nil {
+		m.log.SetJournal(nil)
+		m.journal.Close()
+		m.journal = nil
+	}
+}
+
+// openJournal opens the journal of the service, if the manager keeps
+// them, in place of any that was open before.  Call with the manager lock
+// held.
+func (s *Service) openJournal() {
+	s.closeJournal()
+	if s.mgr == nil || s.mgr.journalDir == "" {
+		return
+	}
+	dir := filepath.Join(s.mgr.journalDir, "services", journalName(s.Name()))
+	j, e := OpenJournal(dir, s.mgr.journalRet)
+	if e != nil {
+		s.errorf("Failed to open journal: %v", e)
+		return
+	}
+	s.journal = j
+	s.slog.SetJournal(j)
+}
+
+func (s *Service) closeJournal() {
+	if s.journal != nil {
+		s.slog.SetJournal(nil)
+		s.journal.Close()
+		s.journal = nil
+	}
+}
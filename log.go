@@ -15,19 +15,93 @@
 package govisor
 
 import (
+	"fmt"
+	"log"
+	"sort"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/gdamore/govisor/rest"
 )
 
 const (
 	MaxLogRecords = 1000
 )
 
+// Log streams.  Output from a process is recorded as coming from its
+// standard output or standard error, and messages from govisor itself
+// as coming from the supervisor.  These are the same as in the REST API.
+const (
+	StreamStdout     = rest.StreamStdout
+	StreamStderr     = rest.StreamStderr
+	StreamSupervisor = rest.StreamSupervisor
+)
+
+// Log levels, for messages from the supervisor.
+const (
+	LevelInfo    = rest.LevelInfo
+	LevelWarning = rest.LevelWarning
+	LevelError   = rest.LevelError
+)
+
+// LogRecord is a single line of a log.  Service is the service that the
+// line is about, and is empty for messages from the manager itself.  Pid
+// is the process that wrote the line, for output captured from a process.
+// Text is the line itself, without the service or stream; String returns
+// the line as it is written to plain text logs.
 type LogRecord struct {
-	Id   int64     `json:"id,string"`
-	Time time.Time `json:"time"`
-	Text string    `json:"text"`
+	Id      int64     `json:"id,string"`
+	Time    time.Time `json:"time"`
+	Text    string    `json:"text"`
+	Service string    `json:"service,omitempty"`
+	Stream  string    `json:"stream,omitempty"`
+	Level   string    `json:"level,omitempty"`
+	Pid     int       `json:"pid,omitempty"`
+}
+
+// String returns the record as a line of text, prefixed by the service
+// name and, for process output, the stream.
+func (r LogRecord) String() string {
+	s := r.Text
+	switch r.Stream {
+	case StreamStdout, StreamStderr:
+		s = r.Stream + "> " + s
+	}
+	if r.Service != "" {
+		s = "[" + r.Service + "] " + s
+	}
+	return s
+}
+
+// recorder is implemented by log destinations that keep the structure of
+// records, rather than just their text.
+type recorder interface {
+	Record(r LogRecord)
+}
+
+// logRecord writes r to logger.  If the logger writes to a recorder, then
+// r is passed along intact, otherwise it is written as a line of text.
+func logRecord(logger *log.Logger, r LogRecord) {
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	if rec, ok := logger.Writer().(recorder); ok {
+		rec.Record(r)
+	} else {
+		logger.Print(r.String())
+	}
+}
+
+// logLevelf writes a message from the supervisor to logger, at the given
+// level.
+func logLevelf(logger *log.Logger, level string, format string,
+	v ...interface{}) {
+	logRecord(logger, LogRecord{
+		Stream: StreamSupervisor,
+		Level:  level,
+		Text:   fmt.Sprintf(format, v...),
+	})
 }
 
 type Log struct {
@@ -35,6 +109,8 @@ type Log struct {
 	numRecords int
 	maxRecords int
 	id         int64
+	dropped    int64 // ID of the newest record no longer in memory
+	journal    *Journal
 	cvs        map[*sync.Cond]bool
 	mx         sync.Mutex
 }
@@ -47,8 +123,52 @@ func (log *Log) unlock() {
 	log.mx.Unlock()
 }
 
-// Write implements the Writer interface consumed by Logger.
+// Write implements the Writer interface consumed by Logger.  Each line
+// is recorded as an informational message from the supervisor.
 func (log *Log) Write(b []byte) (int, error) {
+	str := strings.Trim(string(b), "\n")
+	now := time.Now()
+	log.lock()
+	for _, line := range strings.Split(str, "\n") {
+		log.add(LogRecord{
+			Time:   now,
+			Text:   line,
+			Stream: StreamSupervisor,
+			Level:  LevelInfo,
+		})
+	}
+	log.broadcast()
+	log.unlock()
+	return len(b), nil
+}
+
+// Record adds a record to the log.  The ID is assigned by the log, as is
+// the time, unless the record already has one.
+func (log *Log) Record(r LogRecord) {
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	log.lock()
+	log.add(r)
+	log.broadcast()
+	log.unlock()
+}
+
+// add gives a record the next ID, and stores it, in the journal as well
+// if there is one.  Call with the lock held.
+func (log *Log) add(r LogRecord) {
+	log.id++
+	r.Id = log.id
+	if log.journal != nil {
+		// A failure to write the journal must not stop us logging,
+		// and there is nowhere else to report it.
+		log.journal.Append(r)
+	}
+	log.store(r)
+}
+
+// store keeps a record in memory.  Call with the lock held.
+func (log *Log) store(r LogRecord) {
 	if log.maxRecords == 0 {
 		log.maxRecords = MaxLogRecords
 	}
@@ -56,47 +176,141 @@ func (log *Log) Write(b []byte) (int, error) {
 		log.records = make([]LogRecord, log.maxRecords)
 		log.numRecords = 0
 	}
-	str := strings.Trim(string(b), "\n")
-	log.lock()
-	for _, line := range strings.Split(str, "\n") {
-		idx := log.numRecords % log.maxRecords
-		log.id++
-		log.records[idx].Text = line
-		log.records[idx].Id = log.id
-		log.records[idx].Time = time.Now()
-		// NB: numRecords may actually be more than maxRecords.
-		// In that case, we've looped, but we use this really to
-		// track the next index.
-		log.numRecords++
+	idx := log.numRecords % log.maxRecords
+	if log.numRecords >= log.maxRecords {
+		log.dropped = log.records[idx].Id
 	}
+	log.records[idx] = r
+	// NB: numRecords may actually be more than maxRecords.
+	// In that case, we've looped, but we use this really to
+	// track the next index.
+	log.numRecords++
+}
+
+// broadcast wakes up watchers.  Call with the lock held.
+func (log *Log) broadcast() {
 	for cv := range log.cvs {
 		cv.Broadcast()
 	}
-	log.unlock()
-	return len(b), nil
 }
 
+// SetJournal keeps the records of the log in j, as well as in memory.
+// The most recent records in j are loaded into memory, followed by those
+// that were already there, so that history survives a restart.  Only
+// MaxLogRecords are held in memory, but older records are still read from
+// the journal by GetRecords and ReadRecords when asked for those after an
+// older ID, and by Search.  A nil j stops using the journal, but does not
+// close it.
+func (log *Log) SetJournal(j *Journal) {
+	log.lock()
+	defer log.unlock()
+	log.journal = j
+	if j == nil {
+		return
+	}
+	if log.maxRecords == 0 {
+		log.maxRecords = MaxLogRecords
+	}
+	pending := log.getRecords()
+	recs, _ := j.Tail(log.maxRecords)
+	log.numRecords = 0
+	// Older records are in the journal, and those before it are gone.
+	log.dropped = 0
+	if len(recs) != 0 {
+		log.dropped = recs[0].Id - 1
+	}
+	for _, r := range recs {
+		log.store(r)
+	}
+	if last := j.LastId(); last > log.id {
+		log.id = last
+	}
+	for _, r := range pending {
+		log.add(r)
+	}
+	log.broadcast()
+}
+
+// Clear discards the records in memory.  Any journal is left alone.
 func (log *Log) Clear() {
 	log.lock()
 	log.numRecords = 0
+	log.dropped = log.id
 	// We presume that we cannot add new records more quickly than
 	// once every nanosecond.
-	log.id = time.Now().UnixNano()
+	if id := time.Now().UnixNano(); id > log.id {
+		log.id = id
+	}
 	log.unlock()
 }
 
 // GetRecords returns the records that are stored, as well as an ID
 // suitable for use as an Etag.  The last parameter can be the last ID
-// that was checked, in which case this function will return nil immediately
-// if the log has not changed since that ID was returned, without duplicating
-// any records.  These IDs are suitable for use as an Etag in REST APIs.
-// Note that IDs are not unique across different Log instances.
+// that was checked, in which case this function will return only the
+// records added since, and nil immediately if the log has not changed
+// since that ID was returned, without duplicating any records.  These IDs
+// are suitable for use as an Etag in REST APIs.  Note that IDs are not
+// unique across different Log instances.  If the log has a journal, then
+// the records in memory were first loaded from it, and so include those
+// from before a restart, and if last is older than the records in memory,
+// then the records after it are read from the journal.  See ReadRecords.
 func (log *Log) GetRecords(last int64) ([]LogRecord, int64) {
+	recs, id, _ := log.ReadRecords(last, 0)
+	return recs, id
+}
+
+// ReadRecords returns up to limit records with IDs greater than since, or
+// all of them if limit <= 0, together with the ID that GetRecords would
+// return.  A since of zero returns the records in memory.  Otherwise, if
+// the log has a journal, records older than those in memory are read from
+// it, but no more than MaxLogRecords at a time.  If there are more records
+// than are returned, then the ID is that of the last record returned, so
+// that reading after it continues where this left off.  The bool is true
+// if records after since are no longer kept, so that the caller has fallen
+// behind, and missed them.  The records returned then start with the
+// oldest that is kept.
+func (log *Log) ReadRecords(since int64, limit int) ([]LogRecord, int64, bool) {
 	log.lock()
-	if log.id == last {
+	id := log.id
+	if since == id {
 		log.unlock()
-		return nil, last
+		return nil, id, false
 	}
+	recs := log.getRecords()
+	lost := since != 0 && since < log.dropped
+	j := log.journal
+	log.unlock()
+
+	i := sort.Search(len(recs), func(k int) bool {
+		return recs[k].Id > since
+	})
+	if i == 0 && since != 0 && j != nil {
+		// We can drop the lock, as the journal has its own, and it
+		// only grows after the records we have.
+		first := j.FirstId()
+		if first != 0 && (len(recs) == 0 || first < recs[0].Id) {
+			n := limit
+			if n <= 0 || n > MaxLogRecords {
+				n = MaxLogRecords
+			}
+			if jrecs, e := j.Read(since, n); e == nil {
+				if len(jrecs) == n {
+					id = jrecs[n-1].Id
+				}
+				return jrecs, id, since < first-1
+			}
+		}
+	}
+	recs = recs[i:]
+	if limit > 0 && len(recs) > limit {
+		recs = recs[:limit]
+		id = recs[limit-1].Id
+	}
+	return recs, id, lost
+}
+
+// getRecords returns the records in memory.  Call with the lock held.
+func (log *Log) getRecords() []LogRecord {
 	var recs []LogRecord
 	cnt := log.numRecords
 	cur := log.numRecords
@@ -114,9 +328,7 @@ func (log *Log) GetRecords(last int64) ([]LogRecord, int64) {
 		recs = append(recs, log.records[index%log.maxRecords])
 		index++
 	}
-	id := log.id
-	log.unlock()
-	return recs, id
+	return recs
 }
 
 func (log *Log) Watch(last int64, expire time.Duration) int64 {
@@ -160,5 +372,7 @@ func NewLog() *Log {
 		id:         time.Now().UnixNano(),
 		cvs:        make(map[*sync.Cond]bool),
 	}
+	// Records from before we started are gone.
+	log.dropped = log.id
 	return log
 }
@@ -0,0 +1,290 @@
+// Copyright 2016 The Govisor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package govisor
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DefaultLogKeep is the number of rotated log files kept, if not given.
+const DefaultLogKeep = 5
+
+// LogRotation describes when a log file is rotated, and what is kept.
+// A zero MaxSize or MaxAge disables rotation on that basis.
+type LogRotation struct {
+	MaxSize  int64    `json:"maxSize" yaml:"maxSize" toml:"maxSize"`    // Bytes
+	MaxAge   Duration `json:"maxAge" yaml:"maxAge" toml:"maxAge"`       // Since opened
+	Keep     int      `json:"keep" yaml:"keep" toml:"keep"`             // Rotated files
+	Compress bool     `json:"compress" yaml:"compress" toml:"compress"` // Gzip them
+}
+
+// LogFile is an io.Writer that appends to a file, rotating it as its
+// LogRotation directs.  Rotated files are named with a suffix of .1, .2,
+// and so on, with .1 the newest.  Compressed files also have a .gz suffix.
+// The age of a file is the time since we opened it, or since it was last
+// rotated.
+type LogFile struct {
+	name   string
+	rot    LogRotation
+	f      *os.File
+	size   int64
+	opened time.Time
+	closed bool
+	gz     sync.WaitGroup
+	mx     sync.Mutex
+}
+
+// OpenLogFile opens the named log file, creating it and its directory if
+// need be.
+func OpenLogFile(name string, rot LogRotation) (*LogFile, error) {
+	if rot.Keep <= 0 {
+		rot.Keep = DefaultLogKeep
+	}
+	lf := &LogFile{name: name, rot: rot}
+	if e := os.MkdirAll(filepath.Dir(name), 0755); e != nil {
+		return nil, e
+	}
+	if e := lf.open(); e != nil {
+		return nil, e
+	}
+	return lf, nil
+}
+
+func (lf *LogFile) open() error {
+	f, e := os.OpenFile(lf.name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if e != nil {
+		return e
+	}
+	info, e := f.Stat()
+	if e != nil {
+		f.Close()
+		return e
+	}
+	lf.f = f
+	lf.size = info.Size()
+	lf.opened = time.Now()
+	return nil
+}
+
+// Name returns the name of the file.
+func (lf *LogFile) Name() string {
+	return lf.name
+}
+
+// Write implements io.Writer, rotating the file first if it is due.
+func (lf *LogFile) Write(b []byte) (int, error) {
+	lf.mx.Lock()
+	defer lf.mx.Unlock()
+
+	if lf.closed {
+		return 0, os.ErrClosed
+	}
+	if lf.due(int64(len(b))) {
+		if e := lf.rotate(); e != nil {
+			return 0, e
+		}
+	}
+	if lf.f == nil {
+		if e := lf.open(); e != nil {
+			return 0, e
+		}
+	}
+	n, e := lf.f.Write(b)
+	lf.size += int64(n)
+	return n, e
+}
+
+func (lf *LogFile) due(n int64) bool {
+	if lf.rot.MaxSize > 0 && lf.size > 0 && lf.size+n > lf.rot.MaxSize {
+		return true
+	}
+	if lf.rot.MaxAge > 0 && time.Since(lf.opened) >= time.Duration(lf.rot.MaxAge) {
+		return lf.size > 0
+	}
+	return false
+}
+
+// Rotate rotates the file now.
+func (lf *LogFile) Rotate() error {
+	lf.mx.Lock()
+	defer lf.mx.Unlock()
+	if lf.closed {
+		return os.ErrClosed
+	}
+	return lf.rotate()
+}
+
+func (lf *LogFile) rotate() error {
+	// Shifting files under a compression would lose it.
+	lf.gz.Wait()
+	if lf.f != nil {
+		lf.f.Close()
+		lf.f = nil
+	}
+	rotated := func(i int) string {
+		return fmt.Sprintf("%s.%d", lf.name, i)
+	}
+	os.Remove(rotated(lf.rot.Keep))
+	os.Remove(rotated(lf.rot.Keep) + ".gz")
+	for i := lf.rot.Keep - 1; i > 0; i-- {
+		os.Rename(rotated(i), rotated(i+1))
+		os.Rename(rotated(i)+".gz", rotated(i+1)+".gz")
+	}
+	if e := os.Rename(lf.name, rotated(1)); e != nil && !os.IsNotExist(e) {
+		return e
+	}
+	if lf.rot.Compress {
+		lf.gz.Add(1)
+		go func() {
+			defer lf.gz.Done()
+			compressFile(rotated(1))
+		}()
+	}
+	return lf.open()
+}
+
+// compressFile replaces the file with a gzip compressed copy.
+func compressFile(name string) error {
+	in, e := os.Open(name)
+	if e != nil {
+		return e
+	}
+	defer in.Close()
+	out, e := os.OpenFile(name+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC,
+		0644)
+	if e != nil {
+		return e
+	}
+	zw := gzip.NewWriter(out)
+	_, e = io.Copy(zw, in)
+	if e == nil {
+		e = zw.Close()
+	}
+	if e2 := out.Close(); e == nil {
+		e = e2
+	}
+	if e != nil {
+		os.Remove(name + ".gz")
+		return e
+	}
+	return os.Remove(name)
+}
+
+// Reopen closes and reopens the file, for use after it has been moved
+// aside by some other program, such as logrotate.
+func (lf *LogFile) Reopen() error {
+	lf.mx.Lock()
+	defer lf.mx.Unlock()
+	if lf.closed {
+		return os.ErrClosed
+	}
+	if lf.f != nil {
+		lf.f.Close()
+		lf.f = nil
+	}
+	return lf.open()
+}
+
+// Close closes the file, waiting for any compression to finish.
+func (lf *LogFile) Close() error {
+	lf.mx.Lock()
+	defer lf.mx.Unlock()
+	lf.gz.Wait()
+	lf.closed = true
+	if lf.f == nil {
+		return nil
+	}
+	e := lf.f.Close()
+	lf.f = nil
+	return e
+}
+
+// LogDir returns the directory in which service log files with relative
+// names are kept.  By default, this is under the base directory, in a
+// directory named for the manager.
+func (m *Manager) LogDir() string {
+	m.lock()
+	defer m.unlock()
+	return m.logDirectory()
+}
+
+func (m *Manager) logDirectory() string {
+	if m.logDir != "" || m.baseDir == "" {
+		return m.logDir
+	}
+	return filepath.Join(m.baseDir, m.name, "logs")
+}
+
+// SetLogDir sets the directory for service log files with relative names.
+// Log files that are already open are opened again there.
+func (m *Manager) SetLogDir(dir string) {
+	m.lock()
+	defer m.unlock()
+	m.logDir = dir
+	for s := range m.services {
+		s.openLogFile()
+	}
+}
+
+// ReopenLogs reopens the log files of every service, for use after they
+// have been moved aside by some other program, such as logrotate.
+func (m *Manager) ReopenLogs() {
+	m.lock()
+	defer m.unlock()
+	for s := range m.services {
+		if s.lfile != nil {
+			if e := s.lfile.Reopen(); e != nil {
+				s.errorf("Failed to reopen log file: %v", e)
+			}
+		}
+	}
+}
+
+// openLogFile opens the log file of the service, if it has one, in place of
+// any that was open before.  Call with the manager lock held.
+func (s *Service) openLogFile() {
+	s.closeLogFile()
+	if s.logFile == "" || s.mgr == nil {
+		return
+	}
+	name := s.logFile
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(s.mgr.logDirectory(), name)
+	}
+	lf, e := OpenLogFile(name, s.logRotation)
+	if e != nil {
+		s.errorf("Failed to open log file: %v", e)
+		return
+	}
+	s.lfile = lf
+	s.flogger = log.New(lf, "", log.LstdFlags)
+	s.mlog.AddLogger(s.flogger)
+}
+
+func (s *Service) closeLogFile() {
+	if s.lfile != nil {
+		s.mlog.DelLogger(s.flogger)
+		s.lfile.Close()
+		s.lfile = nil
+		s.flogger = nil
+	}
+}
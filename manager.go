@@ -18,6 +18,7 @@ import (
 	"io"
 	"log"
 	"os"
+	"path/filepath"
 	"runtime"
 	"sync"
 	"time"
@@ -27,6 +28,21 @@ type Manager struct {
 	services   map[*Service]bool
 	name       string
 	baseDir    string
+	stateFile  string
+	stateData  []byte                  // Last state written
+	saved      map[string]serviceState // State restored from file
+	restoring  bool
+	enableNew  bool // Enable services without saved state
+	svcDir     string
+	logDir     string                    // For service log files, see LogDir
+	journalDir string                    // For journals, see SetJournal
+	journalRet JournalRetention          // Retention of journals
+	journal    *Journal                  // The manager's journal
+	loaded     map[string]*loadedService // By manifest file name
+	templates  map[string]*loadedTemplate
+	dynamic    map[string]map[string]bool // Instances from Instantiate
+	reloadMx   sync.Mutex
+	watchq     chan struct{} // Closed to stop watching svcDir
 	logger     *log.Logger
 	mylog      *log.Logger
 	log        *Log
@@ -163,7 +179,7 @@ func (m *Manager) AddService(s *Service) error {
 	for s2 := range m.services {
 		if s.Name() == s2.Name() {
 			m.unlock()
-			m.logf("[%s] Failed to add service [%s]: %v",
+			m.errorf("[%s] Failed to add service [%s]: %v",
 				m.Name(), s.Name(), ErrNameExists)
 			return ErrNameExists
 		}
@@ -224,6 +240,10 @@ func (m *Manager) FindServices(match string) []*Service {
 	return rv
 }
 
+// setBaseDir sets the directory under which the manager keeps its files,
+// by default, in a directory named for it.  This is $GOVISORDIR, or if that
+// is not set, then /var/govisor for root and $HOME/.govisor for others.
+// (Older versions used /var and $HOME, but kept nothing there.)
 func (m *Manager) setBaseDir() {
 	m.baseDir = os.Getenv("GOVISORDIR")
 	switch runtime.GOOS {
@@ -239,9 +259,9 @@ func (m *Manager) setBaseDir() {
 	default:
 		if len(m.baseDir) == 0 {
 			if os.Geteuid() == 0 {
-				m.baseDir = "/var"
-			} else {
-				m.baseDir = os.Getenv("HOME")
+				m.baseDir = "/var/govisor"
+			} else if home := os.Getenv("HOME"); home != "" {
+				m.baseDir = filepath.Join(home, ".govisor")
 			}
 		}
 		if len(m.baseDir) == 0 {
@@ -270,13 +290,21 @@ func (m *Manager) monitor() {
 	for !finish {
 		m.lock()
 		if m.monitoring {
+			now := time.Now()
+			changed := false
 			for s := range m.services {
 				if s.enabled {
+					st := s.savedState()
+					s.runSchedule(now)
 					if e := s.checkService(); e != nil {
 						s.selfHeal()
 					}
+					changed = changed || s.savedState() != st
 				}
 			}
+			if changed {
+				m.saveState()
+			}
 		}
 		if m.cleanup {
 			m.monitoring = false
@@ -305,6 +333,7 @@ func (m *Manager) notify(s *Service) {
 		if e := s.checkService(); e != nil {
 			s.selfHeal()
 		}
+		m.saveState()
 	}
 }
 
@@ -316,6 +345,24 @@ func (m *Manager) logf(format string, v ...interface{}) {
 	}
 }
 
+// warnf logs a warning from the manager.
+func (m *Manager) warnf(format string, v ...interface{}) {
+	m.levelf(LevelWarning, format, v...)
+}
+
+// errorf logs an error from the manager.
+func (m *Manager) errorf(format string, v ...interface{}) {
+	m.levelf(LevelError, format, v...)
+}
+
+func (m *Manager) levelf(level string, format string, v ...interface{}) {
+	if m.mylog != nil {
+		logLevelf(m.mylog, level, format, v...)
+	} else {
+		log.Printf(format, v...)
+	}
+}
+
 func (m *Manager) StopMonitoring() {
 	m.lock()
 	m.monitoring = false
@@ -336,6 +383,13 @@ func (m *Manager) StartMonitoring() {
 func (m *Manager) Shutdown() {
 	m.lock()
 	m.monitoring = false
+	// Tearing down is not an administrative change, so leave the saved
+	// state alone.
+	m.stateFile = ""
+	if m.watchq != nil {
+		close(m.watchq)
+		m.watchq = nil
+	}
 	for s := range m.services {
 		s.enabled = false
 		s.stopRecurse("Shutting down")
@@ -343,6 +397,9 @@ func (m *Manager) Shutdown() {
 	}
 	m.unlock()
 	m.logf("*** Govisor shut down: %s ***", m.name)
+	m.lock()
+	m.closeJournal()
+	m.unlock()
 }
 
 func (m *Manager) GetLog(lastid int64) ([]LogRecord, int64) {
@@ -351,6 +408,12 @@ func (m *Manager) GetLog(lastid int64) ([]LogRecord, int64) {
 	return m.log.GetRecords(lastid)
 }
 
+// ReadLog returns up to limit records from the manager's log, after the
+// one with ID since.  See Log.ReadRecords.
+func (m *Manager) ReadLog(since int64, limit int) ([]LogRecord, int64, bool) {
+	return m.log.ReadRecords(since, limit)
+}
+
 func (m *Manager) WatchLog(old int64, expire time.Duration) int64 {
 	return m.log.Watch(old, expire)
 }
@@ -367,6 +430,9 @@ func NewManager(name string) *Manager {
 	m := &Manager{name: name, serial: time.Now().UnixNano()}
 	m.services = make(map[*Service]bool)
 	m.cvs = make(map[*sync.Cond]bool)
+	m.loaded = make(map[string]*loadedService)
+	m.templates = make(map[string]*loadedTemplate)
+	m.dynamic = make(map[string]map[string]bool)
 	m.createTime = time.Now()
 	m.updateTime = m.createTime
 	m.mlog = NewMultiLogger()
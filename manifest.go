@@ -0,0 +1,161 @@
+// Copyright 2016 The Govisor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package govisor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Manifest formats.  The fields have the same names and meanings in each
+// format, and YAML and TOML permit comments.  Durations may be written in
+// any of them either as a number of nanoseconds, or as a string such as
+// "10s".
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+	FormatTOML = "toml"
+)
+
+// Duration is a length of time in a manifest.  It is given either as a
+// number of nanoseconds, or as a string that time.ParseDuration accepts.
+type Duration time.Duration
+
+// UnmarshalJSON implements json.Unmarshaler, accepting either a number
+// or a string.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	dec := json.NewDecoder(strings.NewReader(string(b)))
+	dec.UseNumber()
+	if e := dec.Decode(&v); e != nil {
+		return e
+	}
+	return d.set(v)
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler, accepting the same values
+// as UnmarshalJSON.
+func (d *Duration) UnmarshalYAML(n *yaml.Node) error {
+	var v interface{}
+	if e := n.Decode(&v); e != nil {
+		return e
+	}
+	return d.set(v)
+}
+
+// UnmarshalTOML implements toml.Unmarshaler, accepting the same values
+// as UnmarshalJSON.
+func (d *Duration) UnmarshalTOML(v interface{}) error {
+	return d.set(v)
+}
+
+func (d *Duration) set(v interface{}) error {
+	switch v := v.(type) {
+	case string:
+		t, e := time.ParseDuration(v)
+		if e != nil {
+			return fmt.Errorf("Bad duration %q", v)
+		}
+		*d = Duration(t)
+	case json.Number:
+		n, e := v.Int64()
+		if e != nil {
+			return fmt.Errorf("Bad duration %s", v)
+		}
+		*d = Duration(n)
+	case int:
+		*d = Duration(v)
+	case int64:
+		*d = Duration(v)
+	default:
+		return ErrBadPropType
+	}
+	return nil
+}
+
+// ManifestFormat returns the format of a manifest file, based upon its
+// extension.  Files with an unrecognized extension are taken to be JSON.
+func ManifestFormat(fname string) string {
+	switch strings.ToLower(filepath.Ext(fname)) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".toml":
+		return FormatTOML
+	}
+	return FormatJSON
+}
+
+// DecodeManifestFormat reads a manifest in the given format, and validates
+// it.  Unknown fields are errors, in every format.
+func DecodeManifestFormat(r io.Reader, format string) (ProcessManifest, error) {
+	var m ProcessManifest
+	switch format {
+	case FormatJSON:
+		return DecodeManifest(r)
+	case FormatYAML:
+		dec := yaml.NewDecoder(r)
+		dec.KnownFields(true)
+		if e := dec.Decode(&m); e != nil {
+			return m, e
+		}
+	case FormatTOML:
+		md, e := toml.NewDecoder(r).Decode(&m)
+		if e != nil {
+			return m, e
+		}
+		if keys := md.Undecoded(); len(keys) != 0 {
+			names := make([]string, 0, len(keys))
+			for _, k := range keys {
+				names = append(names, k.String())
+			}
+			sort.Strings(names)
+			return m, fmt.Errorf("Unknown fields %s",
+				strings.Join(names, ", "))
+		}
+	default:
+		return m, fmt.Errorf("Unknown manifest format %q", format)
+	}
+	return m, validateManifest(m)
+}
+
+// ReadManifest reads the manifest in the named file, using the format
+// given by its extension.
+func ReadManifest(fname string) (ProcessManifest, error) {
+	f, e := os.Open(fname)
+	if e != nil {
+		return ProcessManifest{}, e
+	}
+	defer f.Close()
+	return DecodeManifestFormat(f, ManifestFormat(fname))
+}
+
+// NewProcessFromFile creates a process from the manifest in the named file,
+// which may be in any of the supported formats.
+func NewProcessFromFile(fname string) (*Service, error) {
+	m, e := ReadManifest(fname)
+	if e != nil {
+		return nil, e
+	}
+	return NewProcessFromManifest(m), nil
+}
@@ -18,6 +18,7 @@ import (
 	"log"
 	"strings"
 	"sync"
+	"time"
 )
 
 // MultiLogger implements a wrapper around log.Logger, that permits a single
@@ -25,28 +26,56 @@ import (
 // implements an io.Writer, which breaks up the lines and delivers them
 // each to the various contained loggers.  The contained loggers may have
 // their own Prefix and Flags, and those shall not interfere with the parent.
+//
+// Lines are carried as LogRecords.  Loggers that write to a Log (or to
+// another MultiLogger) receive the records intact, and other loggers
+// receive them as lines of text.
 type MultiLogger struct {
 	log     *log.Logger
 	loggers []*log.Logger
+	service string
 	lock    sync.Mutex
 }
 
 // Write implements the io.Writer, suitable for use with Logger.  It is
 // expected that the input is text, delimited by newlines, and delivered
 // an entire line at a time.  This isn't exactly io.Writer, but it is the
-// semantic to which the log.Logger interface conforms.
+// semantic to which the log.Logger interface conforms.  Each line is
+// an informational message from the supervisor.
 func (l *MultiLogger) Write(b []byte) (int, error) {
 	lines := strings.Split(strings.Trim(string(b), "\n"), "\n")
-	l.lock.Lock()
+	now := time.Now()
 	for _, line := range lines {
-		for _, logger := range l.loggers {
-			logger.Println(line)
-		}
+		l.Record(LogRecord{
+			Time:   now,
+			Text:   line,
+			Stream: StreamSupervisor,
+			Level:  LevelInfo,
+		})
 	}
-	l.lock.Unlock()
 	return len(b), nil
 }
 
+// Record fans out a record to the registered loggers.  If the record has
+// no service, then it is given the one set by SetService.
+func (l *MultiLogger) Record(r LogRecord) {
+	l.lock.Lock()
+	if r.Service == "" {
+		r.Service = l.service
+	}
+	for _, logger := range l.loggers {
+		logRecord(logger, r)
+	}
+	l.lock.Unlock()
+}
+
+// SetService sets the service that records are attributed to.
+func (l *MultiLogger) SetService(name string) {
+	l.lock.Lock()
+	l.service = name
+	l.lock.Unlock()
+}
+
 // AddLogger adds a logger to the MultiLogger.  Once called, all new log entries
 // will be fanned out to this logger, as well as any others that may have been
 // registered earlier.  A logger can only be added once.
@@ -0,0 +1,33 @@
+// Copyright 2016 The Govisor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package govisor
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup is only implemented for POSIX systems.  Elsewhere, only
+// the process itself is signaled.
+func setProcessGroup(c *exec.Cmd) bool {
+	return false
+}
+
+// signalGroup is only implemented for POSIX systems.
+func signalGroup(pgid int, sig syscall.Signal) error {
+	return ErrNotSupported
+}
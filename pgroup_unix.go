@@ -0,0 +1,51 @@
+// Copyright 2016 The Govisor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package govisor
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup arranges for the command to run in a process group of
+// its own, so that anything it starts can be signaled with it.  A command
+// that starts a new session gets a new group anyway.  It returns false if
+// the command was set up to join some other group.
+func setProcessGroup(c *exec.Cmd) bool {
+	attr := &syscall.SysProcAttr{}
+	if c.SysProcAttr != nil {
+		*attr = *c.SysProcAttr
+	}
+	c.SysProcAttr = attr
+	if attr.Setsid {
+		return true
+	}
+	if attr.Setpgid {
+		return attr.Pgid == 0
+	}
+	attr.Setpgid = true
+	return true
+}
+
+// signalGroup sends the signal to every process in the process group.
+func signalGroup(pgid int, sig syscall.Signal) error {
+	if e := syscall.Kill(-pgid, sig); e != nil {
+		return os.NewSyscallError("kill", e)
+	}
+	return nil
+}
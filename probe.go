@@ -0,0 +1,323 @@
+// Copyright 2016 The Govisor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package govisor
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Probe types.
+const (
+	ProbeExec = "exec" // Run a command, healthy if it exits zero
+	ProbeTCP  = "tcp"  // Connect to a TCP host:port
+	ProbeHTTP = "http" // HTTP GET, checking status and optionally body
+	ProbeUnix = "unix" // Connect to a UNIX domain stream socket
+)
+
+const (
+	// DefaultProbeTimeout is the time a single probe may take, when
+	// no timeout is supplied.
+	DefaultProbeTimeout = time.Second * 10
+
+	// maxProbeBody is the most of an HTTP response body that we will
+	// search for the expected body text.
+	maxProbeBody = 64 * 1024
+)
+
+// ProbeManifest describes a health probe for a process.  Each probe
+// runs on its own schedule while the process is running.  A probe becomes
+// healthy after SuccessThreshold consecutive passes, and faults the process
+// after FailureThreshold consecutive failures.  Once it has failed, it
+// recovers only after SuccessThreshold consecutive passes, and if
+// SuccessThreshold is given, then the process is not ready for its
+// dependents until the probe is healthy.
+type ProbeManifest struct {
+	Type             string   `json:"type" yaml:"type" toml:"type"`
+	Command          []string `json:"command" yaml:"command" toml:"command"` // exec
+	Address          string   `json:"address" yaml:"address" toml:"address"` // tcp host:port, unix path
+	URL              string   `json:"url" yaml:"url" toml:"url"`             // http
+	Status           int      `json:"status" yaml:"status" toml:"status"`    // http, 0 means any 2xx
+	Body             string   `json:"body" yaml:"body" toml:"body"`          // http, expected substring
+	Interval         Duration `json:"interval" yaml:"interval" toml:"interval"`
+	Timeout          Duration `json:"timeout" yaml:"timeout" toml:"timeout"`
+	SuccessThreshold int      `json:"successThreshold" yaml:"successThreshold" toml:"successThreshold"`
+	FailureThreshold int      `json:"failureThreshold" yaml:"failureThreshold" toml:"failureThreshold"`
+}
+
+// probe is the runtime state for a single health probe.  Except for the
+// configuration, which is fixed, the fields are protected by the lock of
+// the Process that owns the probe.
+type probe struct {
+	kind      string
+	interval  time.Duration
+	timeout   time.Duration
+	successes int  // threshold to become healthy
+	failures  int  // threshold to fault the process
+	gate      bool // the process is not ready until healthy
+	run       func(p *Process, proc *os.Process, d time.Duration) error
+
+	passes  int   // consecutive passes
+	fails   int   // consecutive failures
+	healthy bool  // true once we have seen enough passes
+	err     error // most recent failure
+}
+
+func (pr *probe) reset() {
+	pr.passes = 0
+	pr.fails = 0
+	pr.healthy = false
+	pr.err = nil
+}
+
+// status returns a short description of the probe state, suitable for
+// inclusion in the service status.
+func (pr *probe) status() string {
+	switch {
+	case pr.fails > 0:
+		return fmt.Sprintf("%s failing %d/%d", pr.kind, pr.fails,
+			pr.failures)
+	case pr.healthy:
+		return pr.kind + " ok"
+	default:
+		return pr.kind + " starting"
+	}
+}
+
+func newProbe(pm ProbeManifest, dir string) (*probe, error) {
+	pr := &probe{
+		kind:      pm.Type,
+		interval:  time.Duration(pm.Interval),
+		timeout:   time.Duration(pm.Timeout),
+		successes: pm.SuccessThreshold,
+		failures:  pm.FailureThreshold,
+		gate:      pm.SuccessThreshold > 0,
+	}
+	if pr.interval <= 0 {
+		pr.interval = DefaultCheckInterval
+	}
+	if pr.timeout <= 0 {
+		pr.timeout = DefaultProbeTimeout
+	}
+	if pr.successes <= 0 {
+		pr.successes = 1
+	}
+	if pr.failures <= 0 {
+		pr.failures = DefaultCheckFailures
+	}
+
+	switch pm.Type {
+	case ProbeExec:
+		if len(pm.Command) == 0 {
+			return nil, fmt.Errorf("Probe %s: missing command", pm.Type)
+		}
+		cmd := exec.Command(pm.Command[0], pm.Command[1:]...)
+		cmd.Dir = dir
+		pr.run = func(p *Process, proc *os.Process, d time.Duration) error {
+			return p.runCmdWithTimeout("check", cmd, proc, d)
+		}
+	case ProbeTCP, ProbeUnix:
+		if pm.Address == "" {
+			return nil, fmt.Errorf("Probe %s: missing address", pm.Type)
+		}
+		network := pm.Type
+		addr := pm.Address
+		pr.run = func(p *Process, proc *os.Process, d time.Duration) error {
+			conn, e := net.DialTimeout(network, addr, d)
+			if e != nil {
+				return e
+			}
+			return conn.Close()
+		}
+	case ProbeHTTP:
+		if pm.URL == "" {
+			return nil, fmt.Errorf("Probe %s: missing url", pm.Type)
+		}
+		url := pm.URL
+		status := pm.Status
+		body := pm.Body
+		pr.run = func(p *Process, proc *os.Process, d time.Duration) error {
+			return httpProbe(url, status, body, d)
+		}
+	default:
+		return nil, fmt.Errorf("Unknown probe type %q", pm.Type)
+	}
+	return pr, nil
+}
+
+func httpProbe(url string, status int, body string, d time.Duration) error {
+	client := &http.Client{Timeout: d}
+	res, e := client.Get(url)
+	if e != nil {
+		return e
+	}
+	defer res.Body.Close()
+
+	if status == 0 {
+		if res.StatusCode < 200 || res.StatusCode > 299 {
+			return fmt.Errorf("Unexpected status: %s", res.Status)
+		}
+	} else if res.StatusCode != status {
+		return fmt.Errorf("Unexpected status: %s", res.Status)
+	}
+	if body == "" {
+		return nil
+	}
+	b, e := ioutil.ReadAll(io.LimitReader(res.Body, maxProbeBody))
+	if e != nil {
+		return e
+	}
+	if !strings.Contains(string(b), body) {
+		return fmt.Errorf("Response body does not contain %q", body)
+	}
+	return nil
+}
+
+// prober runs a single probe periodically, for as long as the process
+// proc is running.  After enough consecutive failures, the process is marked
+// failed, and the service is notified, so that it can stop (and possibly
+// restart) us.  A probe that does not complete in time counts as a failure,
+// which is how wedged processes get caught.
+func (p *Process) prober(pr *probe, proc *os.Process, checkq chan struct{}) {
+	for {
+		select {
+		case <-checkq:
+			return
+		case <-time.After(pr.interval):
+		}
+
+		e := pr.run(p, proc, pr.timeout)
+
+		p.lock.Lock()
+		if p.process != proc || p.stopped || p.failed {
+			p.lock.Unlock()
+			return
+		}
+		if e == nil {
+			pr.passes++
+			if pr.passes < pr.successes {
+				p.lock.Unlock()
+				continue
+			}
+			if pr.fails != 0 {
+				p.logger.Printf("Probe %s passed", pr.kind)
+				pr.fails = 0
+				pr.err = nil
+			}
+			var notify func()
+			if !pr.healthy {
+				pr.healthy = true
+				p.logger.Printf("Probe %s healthy", pr.kind)
+				// The service may now be ready.
+				if pr.gate {
+					notify = p.notify
+				}
+			}
+			p.lock.Unlock()
+			if notify != nil {
+				notify()
+			}
+			continue
+		}
+		pr.passes = 0
+		pr.fails++
+		pr.err = e
+		p.warnf("Probe %s failed (%d of %d): %v",
+			pr.kind, pr.fails, pr.failures, e)
+		if pr.fails < pr.failures {
+			p.lock.Unlock()
+			continue
+		}
+		p.failed = true
+		p.reason = &Fault{
+			Kind: FaultAbnormal,
+			Err:  fmt.Errorf("Health check failed: %v", e),
+		}
+		p.errorf("Failed: %v", p.reason)
+		notify := p.notify
+		p.lock.Unlock()
+
+		if notify != nil {
+			notify()
+		}
+		return
+	}
+}
+
+// startProbes starts all configured probes for the process proc.  The
+// legacy check command is run as an exec probe.  Call with lock held.
+func (p *Process) startProbes(proc *os.Process) {
+	probes := p.probes
+	if p.checkCmd != nil {
+		pr := &probe{
+			kind:      ProbeExec,
+			interval:  p.checkInterval,
+			timeout:   p.checkTimeout,
+			successes: 1,
+			failures:  p.checkFailures,
+		}
+		if pr.interval <= 0 {
+			pr.interval = DefaultCheckInterval
+		}
+		if pr.failures <= 0 {
+			pr.failures = DefaultCheckFailures
+		}
+		cmd := p.checkCmd
+		pr.run = func(p *Process, proc *os.Process, d time.Duration) error {
+			return p.runCmdWithTimeout("check", cmd, proc, d)
+		}
+		probes = append([]*probe{pr}, probes...)
+	}
+	p.active = probes
+	if len(probes) == 0 {
+		return
+	}
+	p.checkq = make(chan struct{})
+	for _, pr := range probes {
+		pr.reset()
+		go p.prober(pr, proc, p.checkq)
+	}
+}
+
+// stopProbes stops any running probes.  Call with lock held.
+func (p *Process) stopProbes() {
+	if p.checkq != nil {
+		close(p.checkq)
+		p.checkq = nil
+	}
+}
+
+// Health returns a summary of the state of the probes for the process.
+// This implements the HealthReporter interface.
+func (p *Process) Health() string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.process == nil || len(p.active) == 0 {
+		return ""
+	}
+	words := make([]string, 0, len(p.active))
+	for _, pr := range p.active {
+		words = append(words, pr.status())
+	}
+	return strings.Join(words, ", ")
+}
@@ -30,19 +30,73 @@ import (
 )
 
 const (
-	PropProcessFailOnExit PropertyName = "_ProcFailOnExit"
-	PropProcessStopCmd                 = "_ProcStopCmd"
-	PropProcessStopTime                = "_ProcStopTime"
-	PropProcessCheckCmd                = "_ProcCheckCmd"
-	PropProcessDirectory               = "_ProcDirectory"
+	PropProcessFailOnExit    PropertyName = "_ProcFailOnExit"
+	PropProcessStopCmd                    = "_ProcStopCmd"
+	PropProcessStopTime                   = "_ProcStopTime"
+	PropProcessCheckCmd                   = "_ProcCheckCmd"
+	PropProcessCheckInterval              = "_ProcCheckInterval"
+	PropProcessCheckTimeout               = "_ProcCheckTimeout"
+	PropProcessCheckFailures              = "_ProcCheckFailures"
+	PropProcessDirectory                  = "_ProcDirectory"
+	PropProcessType                       = "_ProcType"
+	PropProcessReadyTimeout               = "_ProcReadyTimeout"
+	PropProcessEnv                        = "_ProcEnv"
+	PropProcessEnvFiles                   = "_ProcEnvFiles"
+	PropProcessCleanEnv                   = "_ProcCleanEnv"
+	PropProcessUser                       = "_ProcUser"
+	PropProcessGroup                      = "_ProcGroup"
+	PropProcessGroups                     = "_ProcGroups"
+	PropProcessLimitNoFile                = "_ProcLimitNoFile"
+	PropProcessLimitNProc                 = "_ProcLimitNProc"
+	PropProcessLimitCore                  = "_ProcLimitCore"
+	PropProcessLimitAS                    = "_ProcLimitAS"
+	PropProcessNice                       = "_ProcNice"
+	PropProcessCPUAffinity                = "_ProcCPUAffinity"
+	PropProcessUmask                      = "_ProcUmask"
+	PropProcessOOMScoreAdj                = "_ProcOOMScoreAdj"
+	PropProcessStopSignal                 = "_ProcStopSignal"
+	PropProcessStopSignals                = "_ProcStopSignals"
+	PropProcessReloadCmd                  = "_ProcReloadCmd"
+	PropProcessReloadSignal               = "_ProcReloadSignal"
+)
+
+// Process types.  The type determines when a started process is ready,
+// and hence when services that depend upon it may be started.
+const (
+	ProcessSimple  = "simple"  // Ready as soon as it is started
+	ProcessNotify  = "notify"  // Ready when it sends READY=1 to $NOTIFY_SOCKET
+	ProcessOneshot = "oneshot" // Ready when it has run to completion
+)
+
+const (
+	// DefaultCheckInterval is the time between health checks, when
+	// a check command is supplied without an interval.
+	DefaultCheckInterval = time.Second * 10
+
+	// DefaultCheckFailures is the number of consecutive health check
+	// failures that must be observed before the process is faulted.
+	DefaultCheckFailures = 3
+)
+
+// Restart backoff defaults for processes created from manifests.  These
+// take the place of the rate limit, which is disabled for such processes.
+const (
+	DefaultRestartDelay      = time.Second
+	DefaultRestartMaxDelay   = time.Minute
+	DefaultRestartMultiplier = 2.0
+	DefaultRestartJitter     = 0.1
+	DefaultRestartResetAfter = time.Minute
 )
 
 //
 // Process represents an actual operating system level process.  This implements
 // the Provider interface, and hence Process objects can be used as such.
 //
+// Processes are started in a process group of their own, so that stop
+// signals reach anything that they start, and whatever is left of the group
+// is killed when the process exits.
+//
 // XXX: is there any reason for this to be public?
-// XXX: Should we support Setsid and other SysProcAttr settings?
 //
 type Process struct {
 	name      string      // This is the Govisor name, must be set
@@ -57,23 +111,75 @@ type Process struct {
 
 	stopTime   time.Duration // Time to wait for clean shutdown, 0 = forever
 	failOnExit bool          // If true, mark failed if the process exits.
+	restart    RestartMode   // From the service, clean exits may restart
 	stopCmd    *exec.Cmd
 	checkCmd   *exec.Cmd
 	startCmd   *exec.Cmd
 	process    *os.Process
+	pgid       int // Process group to signal, 0 if none
 	directory  string
+	notify     func()
+
+	stopSignal  string     // Signal to stop with, SIGTERM if empty
+	stopSignals []StopStep // Stop sequence, replaces stopSignal and stopTime
+
+	reloadCmd    *exec.Cmd // Command to reload, replaces reloadSignal
+	reloadSignal string    // Signal to reload with, SIGHUP if empty
+
+	env      []string // NAME=VALUE, added to the environment
+	envFiles []string // dotenv files, optional if prefixed with "-"
+	cleanEnv bool     // If true, do not inherit our environment
+
+	user   string   // User to run as, name or ID
+	group  string   // Group to run as, name or ID
+	groups []string // Supplementary groups
+
+	limitNoFile Rlimit // RLIMIT_NOFILE, inherited if empty
+	limitNProc  Rlimit // RLIMIT_NPROC, inherited if empty
+	limitCore   Rlimit // RLIMIT_CORE, inherited if empty
+	limitAS     Rlimit // RLIMIT_AS, inherited if empty
+	nice        int    // Nice level, inherited if zero
+	affinity    []int  // CPUs to run on, any if empty
+	umask       string // Octal file creation mask, inherited if empty
+	oomScoreAdj int    // OOM killer score adjustment, inherited if zero
+
+	kind         string        // Process type, ProcessSimple if empty
+	ready        bool          // True once a notify process is ready
+	completed    bool          // True once a oneshot process exits cleanly
+	readyTimeout time.Duration // Time to wait for readiness, 0 = forever
+	notifySock   *notifySocket // Socket for readiness notification
+	invocation   string        // Unique to this start, see invocationEnv
+	stragglers   []straggler   // Left outside the process group
+
+	checkInterval time.Duration // Time between health checks
+	checkTimeout  time.Duration // Time limit for a single check
+	checkFailures int           // Consecutive failures before faulting
+	checkq        chan struct{} // Closed to stop the probes
+	probes        []*probe      // Health probes from the manifest
+	active        []*probe      // Probes running for current process
 
 	lock   sync.Mutex
 	waiter sync.WaitGroup
 }
 
-func (p *Process) doLog(r io.ReadCloser, prefix string) {
+// doLog records the output of process pid on the given stream.  Output
+// from commands other than the process itself is labeled with the
+// command, such as "stop".
+func (p *Process) doLog(r io.ReadCloser, stream string, label string, pid int) {
 	// Gather stdin/stdout in chunks of lines
 	reader := bufio.NewReader(r)
 	for {
 		line, err := reader.ReadString('\n')
 		if len(line) != 0 {
-			p.logger.Print(prefix, strings.Trim(line, "\n"))
+			text := strings.Trim(line, "\n")
+			if label != "" {
+				text = label + ": " + text
+			}
+			logRecord(p.logger, LogRecord{
+				Stream: stream,
+				Pid:    pid,
+				Text:   text,
+			})
 		}
 		if err != nil {
 			return
@@ -81,6 +187,16 @@ func (p *Process) doLog(r io.ReadCloser, prefix string) {
 	}
 }
 
+// warnf logs a warning from the supervisor.
+func (p *Process) warnf(format string, v ...interface{}) {
+	logLevelf(p.logger, LevelWarning, format, v...)
+}
+
+// errorf logs an error from the supervisor.
+func (p *Process) errorf(format string, v ...interface{}) {
+	logLevelf(p.logger, LevelError, format, v...)
+}
+
 func (p *Process) Name() string {
 	return p.name
 }
@@ -107,25 +223,76 @@ func (p *Process) Depends() []string {
 	return copyArray(p.depends)
 }
 
-func (p *Process) doWait(cmd *exec.Cmd) {
+func (p *Process) doWait(cmd *exec.Cmd, invocation string) {
 
-	e := cmd.Wait()
+	e := waitChild(cmd)
+	// Its pid may now be reused, so only those that carry the invocation
+	// ID in their environment can be told apart as ours.  Scanning takes
+	// a while, so it is done without the lock.
+	ss := findStragglers(0, invocation)
 	p.lock.Lock()
 	p.process = nil
+	p.stopProbes()
+	if p.pgid != 0 {
+		// Anything the process left behind goes with it.
+		if signalGroup(p.pgid, syscall.SIGKILL) == nil {
+			p.warnf("Killed remaining processes")
+		}
+		p.pgid = 0
+	}
+	// As do any that left the group, whether found when stopping, or
+	// by the invocation ID.
+	ss = append(ss, p.stragglers...)
+	if n := signalStragglers(ss, 0, syscall.SIGKILL); n != 0 {
+		p.warnf("Killed %d stray processes", n)
+	}
+	p.stragglers = nil
+	if p.notifySock != nil {
+		p.notifySock.close()
+		p.notifySock = nil
+	}
 	if !p.stopped {
 		if e != nil {
 			p.failed = true
-			p.reason = e
-			p.logger.Printf("Failed: %v", e)
+			p.reason = exitFault(e)
+			p.errorf("Failed: %v", e)
+		} else if p.kind == ProcessOneshot {
+			p.completed = true
+			p.logger.Printf("Completed")
 		} else if p.failOnExit {
 			e = errors.New("Unexpected termination")
-			p.reason = e
+			p.reason = &Fault{Kind: FaultFailure, Err: e}
 			p.failed = true
-			p.logger.Printf("Failed: %v", e)
+			p.errorf("Failed: %v", e)
+		} else if p.restart == RestartAlways {
+			// Not a failure as such, but we have to report it for
+			// the service to restart us.
+			e = errors.New("Process exited")
+			p.reason = &Fault{Kind: FaultExit, Err: e}
+			p.failed = true
+			p.logger.Printf("Exited cleanly")
 		}
 	}
+	notify := p.notify
+	failed := p.failed || p.completed
 	p.lock.Unlock()
 	p.waiter.Done()
+
+	if failed && notify != nil {
+		notify()
+	}
+}
+
+// exitFault classifies the error from Wait.  Processes killed by a signal
+// terminated abnormally, whereas a non-zero exit status is a plain failure.
+func exitFault(e error) error {
+	kind := FaultFailure
+	if ee, ok := e.(*exec.ExitError); ok {
+		if ws, ok := ee.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			kind = FaultAbnormal
+		}
+	}
+	return &Fault{Kind: kind, Err: e}
 }
 
 func (p *Process) Start() error {
@@ -136,107 +303,243 @@ func (p *Process) Start() error {
 	p.stopped = false
 	p.failed = false
 	p.reason = nil
+	p.ready = false
+	p.completed = false
 
-	cmd := &exec.Cmd{}
-	*cmd = *p.startCmd
+	env, e := p.environ()
+	if e != nil {
+		p.errorf("Failed to load environment: %v", e)
+		p.failed = true
+		p.reason = e
+		return e
+	}
+	cmd, e := p.command(p.startCmd, env)
+	if e != nil {
+		p.errorf("Failed to set credentials: %v", e)
+		p.failed = true
+		p.reason = e
+		return e
+	}
+	group := setProcessGroup(cmd)
+	p.invocation = fmt.Sprintf("%x", time.Now().UnixNano())
+	p.stragglers = nil
+	cmd.Env = append(cmd.Env, invocationEnv+"="+p.invocation)
 
-	// XXX: search path
+	if p.kind == ProcessNotify {
+		ns, e := newNotifySocket(env.cred)
+		if e != nil {
+			p.errorf("Failed to create notify socket: %v", e)
+			p.failed = true
+			p.reason = e
+			return e
+		}
+		cmd.Env = append(cmd.Env, "NOTIFY_SOCKET="+ns.path())
+		p.notifySock = ns
+	}
 
+	var stdout, stderr io.ReadCloser
 	if cmd.Stdout == nil {
-		stdout, e := cmd.StdoutPipe()
-		if e != nil {
-			p.logger.Printf("Failed to capture stdout: %v", e)
-		} else {
-			go p.doLog(stdout, "stdout> ")
+		if stdout, e = cmd.StdoutPipe(); e != nil {
+			p.errorf("Failed to capture stdout: %v", e)
+			stdout = nil
 		}
 	}
 	if cmd.Stderr == nil {
-		stderr, e := cmd.StderrPipe()
-		if e != nil {
-			p.logger.Printf("Failed to capture stderr: %v", e)
-		} else {
-			go p.doLog(stderr, "stderr> ")
+		if stderr, e = cmd.StderrPipe(); e != nil {
+			p.errorf("Failed to capture stderr: %v", e)
+			stderr = nil
 		}
 	}
 
-	if e := cmd.Start(); e != nil {
+	ln, e := p.newLauncher(cmd)
+	if e != nil {
+		p.errorf("Failed to set resource controls: %v", e)
+	} else if e = startChild(cmd); e != nil {
+		ln.close()
+	} else if e = ln.wait(); e != nil {
+		// The launcher has exited, without running the command.
+		p.errorf("Failed to launch: %v", e)
+		waitChild(cmd)
+	}
+	if e != nil {
+		if p.notifySock != nil {
+			p.notifySock.close()
+			p.notifySock = nil
+		}
 		p.failed = true
 		p.reason = e
 		return e
 	}
+	if stdout != nil {
+		go p.doLog(stdout, StreamStdout, "", cmd.Process.Pid)
+	}
+	if stderr != nil {
+		go p.doLog(stderr, StreamStderr, "", cmd.Process.Pid)
+	}
 	p.logger.Printf("Process id %d", cmd.Process.Pid)
 	p.process = cmd.Process
+	p.pgid = 0
+	if group {
+		p.pgid = cmd.Process.Pid
+	}
 	p.waiter.Add(1)
 
-	go p.doWait(cmd)
+	if p.notifySock != nil {
+		go p.listenNotify(p.notifySock, cmd.Process)
+		if p.readyTimeout > 0 {
+			proc := cmd.Process
+			time.AfterFunc(p.readyTimeout, func() {
+				p.readyTimedOut(proc)
+			})
+		}
+	}
+
+	go p.doWait(cmd, p.invocation)
+
+	p.startProbes(cmd.Process)
 
 	return nil
 }
 
-func (p *Process) runCmdWithTimeout(pfx string, c *exec.Cmd, d time.Duration) error {
-	newc := &exec.Cmd{}
-	*newc = *c
-	if proc := p.process; proc != nil {
-		if c.Env == nil {
-			newc.Env = os.Environ()
-		}
-		newc.Env = append(make([]string, 0, len(newc.Env)+1), newc.Env...)
+func (p *Process) runCmdWithTimeout(pfx string, c *exec.Cmd, proc *os.Process, d time.Duration) error {
+	newc, e := p.prepareCmd(c, proc)
+	if e != nil {
+		return e
+	}
+	return p.runPrepared(pfx, newc, d)
+}
+
+// prepareCmd returns a copy of c, with the environment and credentials of
+// the process, and $PID set to that of proc.
+func (p *Process) prepareCmd(c *exec.Cmd, proc *os.Process) (*exec.Cmd, error) {
+	env, e := p.environ()
+	if e != nil {
+		return nil, e
+	}
+	newc, e := p.command(c, env)
+	if e != nil {
+		return nil, e
+	}
+	if proc != nil {
 		newc.Env = append(newc.Env, fmt.Sprintf("PID=%d", proc.Pid))
 	}
 
-	// XXX: search path
 	// XXX: expand $PID in args
 
+	return newc, nil
+}
+
+// runPrepared runs a command from prepareCmd, killing it if it takes
+// longer than d, or ten seconds if d is zero.
+func (p *Process) runPrepared(pfx string, newc *exec.Cmd, d time.Duration) error {
 	if d == 0 {
 		d = time.Second * 10
 	}
-	if stderr, e := newc.StderrPipe(); e != nil {
-		p.logger.Printf("Failed to capture stderr: %v", e)
-	} else {
-		go p.doLog(stderr, pfx+"stderr> ")
+	stderr, e := newc.StderrPipe()
+	if e != nil {
+		p.errorf("Failed to capture stderr: %v", e)
+		stderr = nil
 	}
-	if stdout, e := newc.StdoutPipe(); e != nil {
-		p.logger.Printf("Failed to capture stdout: %v", e)
-	} else {
-		go p.doLog(stdout, pfx+"stdout> ")
+	stdout, e := newc.StdoutPipe()
+	if e != nil {
+		p.errorf("Failed to capture stdout: %v", e)
+		stdout = nil
 	}
 
-	if e := newc.Start(); e != nil {
+	if e := startChild(newc); e != nil {
 		return e
 	}
-	proc := newc.Process
+	child := newc.Process
+	if stderr != nil {
+		go p.doLog(stderr, StreamStderr, pfx, child.Pid)
+	}
+	if stdout != nil {
+		go p.doLog(stdout, StreamStdout, pfx, child.Pid)
+	}
 	timer := time.AfterFunc(d, func() {
-		p.logger.Printf("Timeout waiting for %s command", pfx)
-		proc.Kill()
+		p.warnf("Timeout waiting for %s command", pfx)
+		child.Kill()
 	})
-	e := newc.Wait()
+	e = waitChild(newc)
 	timer.Stop()
 	return e
 }
 
-func (p *Process) shutdown() {
-	if proc := p.process; proc != nil && proc.Pid != -1 {
-		if p.stopCmd == nil {
-			e := proc.Signal(syscall.SIGTERM)
-			if e != nil {
-				p.logger.Printf("Failed sending SIGTERM: %v", e)
-			}
+// stopSteps returns the steps to stop the process.  Unless a sequence of
+// signals was given, this is the stop command or signal (by default
+// SIGTERM), and then SIGKILL once the stop time has passed, if there is a
+// stop time.
+func (p *Process) stopSteps() []stopStep {
+	if len(p.stopSignals) != 0 {
+		steps, e := parseStopSteps(p.stopSignals)
+		if e == nil {
+			return steps
+		}
+		p.errorf("Bad stop signals: %v", e)
+	}
+	first := stopStep{sig: syscall.SIGTERM, name: "SIGTERM"}
+	if p.stopCmd != nil {
+		first = stopStep{cmd: true, name: "stop command"}
+	} else if p.stopSignal != "" {
+		if sig, e := ParseSignal(p.stopSignal); e != nil {
+			p.errorf("Bad stop signal: %v", e)
 		} else {
-			// Put the Pid into the environment as $PID
-			e := p.runCmdWithTimeout("stop", p.stopCmd, p.stopTime)
-			if e != nil {
-				p.logger.Printf("Failed stop cmd: %v", e)
-			}
+			first = stopStep{sig: sig, name: SignalName(sig)}
 		}
 	}
+	if p.stopTime <= 0 {
+		return []stopStep{first}
+	}
+	first.wait = p.stopTime
+	return []stopStep{first, {sig: syscall.SIGKILL, name: "SIGKILL"}}
 }
 
-func (p *Process) kill() {
-	if proc := p.process; proc != nil {
-		e := proc.Kill()
+// shutdown carries out one step of stopping the process.
+func (p *Process) shutdown(proc *os.Process, step stopStep) {
+	if proc.Pid == -1 {
+		return
+	}
+	if step.cmd {
+		p.logger.Printf("Running stop command")
+		// Put the Pid into the environment as $PID
+		e := p.runCmdWithTimeout("stop", p.stopCmd, proc, p.stopTime)
 		if e != nil {
-			p.logger.Printf("Failed killing: %v", e)
+			p.errorf("Failed stop cmd: %v", e)
 		}
+		return
+	}
+	p.logger.Printf("Sending %s", step.name)
+	if e := p.signal(proc, step.sig); e != nil {
+		p.errorf("Failed sending %s: %v", step.name, e)
+	}
+	if n := signalStragglers(p.stragglers, p.pgid, step.sig); n != 0 {
+		p.logger.Printf("Sent %s to %d stray processes", step.name, n)
+	}
+}
+
+// signal sends the signal to the process, and to the rest of its process
+// group if it has one.
+func (p *Process) signal(proc *os.Process, sig syscall.Signal) error {
+	if p.pgid != 0 {
+		return signalGroup(p.pgid, sig)
+	}
+	return proc.Signal(sig)
+}
+
+// waitExit waits for the exited channel to be closed, for at most d, or
+// forever if d is zero.  It returns true if the channel was closed.
+func waitExit(exited chan struct{}, d time.Duration) bool {
+	if d <= 0 {
+		<-exited
+		return true
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-exited:
+		return true
+	case <-timer.C:
+		return false
 	}
 }
 
@@ -244,28 +547,106 @@ func (p *Process) Stop() {
 
 	p.lock.Lock()
 	p.stopped = true
+	p.stopProbes()
+	proc := p.process
+	invocation := p.invocation
+	p.lock.Unlock()
+
+	// Find what the process has left outside its group while it is
+	// still running, and they are still its descendants.  Scanning takes
+	// a while, so it is done without the lock.
+	var ss []straggler
+	if proc != nil {
+		ss = findStragglers(proc.Pid, invocation)
+	}
+
+	p.lock.Lock()
+	if proc != nil && p.process != proc {
+		// It exited meanwhile, and left these behind.
+		signalStragglers(ss, 0, syscall.SIGKILL)
+		ss = nil
+	}
 	if proc := p.process; proc != nil {
-		var timer *time.Timer
-		p.shutdown()
-		if p.stopTime > 0 {
-			timer = time.AfterFunc(p.stopTime, func() {
-				p.logger.Printf("Graceful shutdown timed out")
-				p.lock.Lock()
-				p.kill()
-				p.lock.Unlock()
-			})
+		exited := make(chan struct{})
+		go func() {
+			p.waiter.Wait()
+			close(exited)
+		}()
+		p.stragglers = ss
+		gone := false
+		for _, step := range p.stopSteps() {
+			p.shutdown(proc, step)
+			p.lock.Unlock()
+			gone = waitExit(exited, step.wait)
+			p.lock.Lock()
+			if gone {
+				break
+			}
+			p.warnf("Still running %v after %s", step.wait,
+				step.name)
 		}
-		p.lock.Unlock()
-		p.waiter.Wait()
-		p.lock.Lock()
-		if timer != nil {
-			timer.Stop()
+		if !gone {
+			p.lock.Unlock()
+			<-exited
+			p.lock.Lock()
 		}
 	}
 	p.process = nil
 	p.lock.Unlock()
 }
 
+// Reload asks the process to reload its configuration, by running the
+// reload command if there is one, or else by sending it the reload signal.
+// Only the process itself is signaled, as the rest of its group may not
+// expect it.
+func (p *Process) Reload() error {
+	p.lock.Lock()
+	proc := p.process
+	var c *exec.Cmd
+	var e error
+	sig := syscall.SIGHUP
+	switch {
+	case proc == nil:
+		e = ErrNotRunning
+	case p.reloadCmd != nil:
+		// Put the Pid into the environment as $PID
+		c, e = p.prepareCmd(p.reloadCmd, proc)
+	case p.reloadSignal != "":
+		sig, e = ParseSignal(p.reloadSignal)
+	}
+	p.lock.Unlock()
+	if e != nil {
+		return e
+	}
+
+	// The reload command may take a while, so it runs without the lock.
+	if c != nil {
+		p.logger.Printf("Running reload command")
+		return p.runPrepared("reload", c, 0)
+	}
+	p.logger.Printf("Sending %s", SignalName(sig))
+	return proc.Signal(sig)
+}
+
+// Signal sends the signal to the process, or if group is true, to its
+// process group.
+func (p *Process) Signal(sig syscall.Signal, group bool) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	proc := p.process
+	if proc == nil {
+		return ErrNotRunning
+	}
+	if group {
+		if p.pgid == 0 {
+			return ErrNoGroup
+		}
+		return signalGroup(p.pgid, sig)
+	}
+	return proc.Signal(sig)
+}
+
 func (p *Process) Check() error {
 	p.lock.Lock()
 	defer p.lock.Unlock()
@@ -303,12 +684,182 @@ func (p *Process) SetProperty(n PropertyName, v interface{}) error {
 			return nil
 		}
 		return ErrBadPropType
+	case PropProcessReloadCmd:
+		if v, ok := v.(*exec.Cmd); ok {
+			p.reloadCmd = new(exec.Cmd)
+			*p.reloadCmd = *v
+			return nil
+		}
+		return ErrBadPropType
+	case PropProcessReloadSignal:
+		if v, ok := v.(string); ok {
+			if v != "" {
+				if _, e := ParseSignal(v); e != nil {
+					return ErrBadPropValue
+				}
+			}
+			p.reloadSignal = v
+			return nil
+		}
+		return ErrBadPropType
+	case PropProcessCheckCmd:
+		if v, ok := v.(*exec.Cmd); ok {
+			p.checkCmd = new(exec.Cmd)
+			*p.checkCmd = *v
+			return nil
+		}
+		return ErrBadPropType
+	case PropProcessCheckInterval:
+		if v, ok := v.(time.Duration); ok {
+			p.checkInterval = v
+			return nil
+		}
+		return ErrBadPropType
+	case PropProcessCheckTimeout:
+		if v, ok := v.(time.Duration); ok {
+			p.checkTimeout = v
+			return nil
+		}
+		return ErrBadPropType
+	case PropProcessCheckFailures:
+		if v, ok := v.(int); ok {
+			p.checkFailures = v
+			return nil
+		}
+		return ErrBadPropType
 	case PropProcessDirectory:
 		if v, ok := v.(string); ok {
 			p.directory = v
 			return nil
 		}
 		return ErrBadPropType
+	case PropProcessType:
+		if v, ok := v.(string); ok {
+			switch v {
+			case "", ProcessSimple, ProcessNotify, ProcessOneshot:
+				p.kind = v
+				return nil
+			}
+			return ErrBadPropValue
+		}
+		return ErrBadPropType
+	case PropProcessReadyTimeout:
+		if v, ok := v.(time.Duration); ok {
+			p.readyTimeout = v
+			return nil
+		}
+		return ErrBadPropType
+	case PropProcessEnv:
+		if v, ok := v.([]string); ok {
+			p.env = copyArray(v)
+			return nil
+		}
+		return ErrBadPropType
+	case PropProcessEnvFiles:
+		if v, ok := v.([]string); ok {
+			p.envFiles = copyArray(v)
+			return nil
+		}
+		return ErrBadPropType
+	case PropProcessCleanEnv:
+		if v, ok := v.(bool); ok {
+			p.cleanEnv = v
+			return nil
+		}
+		return ErrBadPropType
+	case PropProcessUser:
+		if v, ok := v.(string); ok {
+			p.user = v
+			return nil
+		}
+		return ErrBadPropType
+	case PropProcessGroup:
+		if v, ok := v.(string); ok {
+			p.group = v
+			return nil
+		}
+		return ErrBadPropType
+	case PropProcessGroups:
+		if v, ok := v.([]string); ok {
+			p.groups = copyArray(v)
+			return nil
+		}
+		return ErrBadPropType
+	case PropProcessLimitNoFile:
+		return setRlimit(&p.limitNoFile, v)
+	case PropProcessLimitNProc:
+		return setRlimit(&p.limitNProc, v)
+	case PropProcessLimitCore:
+		return setRlimit(&p.limitCore, v)
+	case PropProcessLimitAS:
+		return setRlimit(&p.limitAS, v)
+	case PropProcessNice:
+		if v, ok := v.(int); ok {
+			if v < -20 || v > 19 {
+				return ErrBadPropValue
+			}
+			p.nice = v
+			return nil
+		}
+		return ErrBadPropType
+	case PropProcessCPUAffinity:
+		if v, ok := v.([]int); ok {
+			p.affinity = append([]int{}, v...)
+			return nil
+		}
+		return ErrBadPropType
+	case PropProcessUmask:
+		if v, ok := v.(string); ok {
+			if v != "" {
+				if _, e := parseUmask(v); e != nil {
+					return ErrBadPropValue
+				}
+			}
+			p.umask = v
+			return nil
+		}
+		return ErrBadPropType
+	case PropProcessOOMScoreAdj:
+		if v, ok := v.(int); ok {
+			if v < -1000 || v > 1000 {
+				return ErrBadPropValue
+			}
+			p.oomScoreAdj = v
+			return nil
+		}
+		return ErrBadPropType
+	case PropProcessStopSignal:
+		if v, ok := v.(string); ok {
+			if v != "" {
+				if _, e := ParseSignal(v); e != nil {
+					return ErrBadPropValue
+				}
+			}
+			p.stopSignal = v
+			return nil
+		}
+		return ErrBadPropType
+	case PropProcessStopSignals:
+		if v, ok := v.([]StopStep); ok {
+			if _, e := parseStopSteps(v); e != nil {
+				return ErrBadPropValue
+			}
+			p.stopSignals = append([]StopStep{}, v...)
+			return nil
+		}
+		return ErrBadPropType
+	case PropNotify:
+		if v, ok := v.(func()); ok {
+			p.notify = v
+			return nil
+		}
+		return ErrBadPropType
+	case PropRestart:
+		if v, ok := v.(RestartMode); ok {
+			p.restart = v
+			return nil
+		}
+		return ErrBadPropType
 	}
 	return ErrBadPropName
 }
@@ -323,26 +874,107 @@ func (p *Process) Property(n PropertyName) (interface{}, error) {
 		return p.stopTime, nil
 	case PropProcessStopCmd:
 		return p.stopCmd, nil
+	case PropProcessCheckCmd:
+		return p.checkCmd, nil
+	case PropProcessReloadCmd:
+		return p.reloadCmd, nil
+	case PropProcessReloadSignal:
+		return p.reloadSignal, nil
+	case PropProcessCheckInterval:
+		return p.checkInterval, nil
+	case PropProcessCheckTimeout:
+		return p.checkTimeout, nil
+	case PropProcessCheckFailures:
+		return p.checkFailures, nil
 	case PropProcessDirectory:
 		return p.directory, nil
+	case PropProcessType:
+		return p.kind, nil
+	case PropProcessReadyTimeout:
+		return p.readyTimeout, nil
+	case PropProcessEnv:
+		return copyArray(p.env), nil
+	case PropProcessEnvFiles:
+		return copyArray(p.envFiles), nil
+	case PropProcessCleanEnv:
+		return p.cleanEnv, nil
+	case PropProcessUser:
+		return p.user, nil
+	case PropProcessGroup:
+		return p.group, nil
+	case PropProcessGroups:
+		return copyArray(p.groups), nil
+	case PropProcessLimitNoFile:
+		return p.limitNoFile, nil
+	case PropProcessLimitNProc:
+		return p.limitNProc, nil
+	case PropProcessLimitCore:
+		return p.limitCore, nil
+	case PropProcessLimitAS:
+		return p.limitAS, nil
+	case PropProcessNice:
+		return p.nice, nil
+	case PropProcessCPUAffinity:
+		return append([]int{}, p.affinity...), nil
+	case PropProcessUmask:
+		return p.umask, nil
+	case PropProcessOOMScoreAdj:
+		return p.oomScoreAdj, nil
+	case PropProcessStopSignal:
+		return p.stopSignal, nil
+	case PropProcessStopSignals:
+		return append([]StopStep{}, p.stopSignals...), nil
 	}
 	return nil, ErrBadPropName
 }
 
 type ProcessManifest struct {
-	Name        string        `json:"name"`
-	Description string        `json:"description"`
-	Command     []string      `json:"command"`
-	Env         []string      `json:"env"`
-	StopCmd     []string      `json:"stopCommand"`
-	StopTime    time.Duration `json:"stopTime"`
-	FailOnExit  bool          `json:"failOnExit"`
-	CheckCmd    []string      `json:"check"`
-	Restart     bool          `json:"restart"`
-	Provides    []string      `json:"provides"`
-	Depends     []string      `json:"depends"`
-	Conflicts   []string      `json:"conflicts"`
-	Directory   string        `json:"directory"`
+	Name              string          `json:"name" yaml:"name" toml:"name"`
+	Description       string          `json:"description" yaml:"description" toml:"description"`
+	Type              string          `json:"type" yaml:"type" toml:"type"`
+	ReadyTimeout      Duration        `json:"readyTimeout" yaml:"readyTimeout" toml:"readyTimeout"`
+	Schedule          string          `json:"schedule" yaml:"schedule" toml:"schedule"`
+	Command           []string        `json:"command" yaml:"command" toml:"command"`
+	Env               []string        `json:"env" yaml:"env" toml:"env"`
+	EnvFiles          []string        `json:"envFiles" yaml:"envFiles" toml:"envFiles"`
+	CleanEnv          bool            `json:"cleanEnv" yaml:"cleanEnv" toml:"cleanEnv"`
+	User              string          `json:"user" yaml:"user" toml:"user"`
+	Group             string          `json:"group" yaml:"group" toml:"group"`
+	Groups            []string        `json:"supplementaryGroups" yaml:"supplementaryGroups" toml:"supplementaryGroups"`
+	LimitNoFile       Rlimit          `json:"limitNofile" yaml:"limitNofile" toml:"limitNofile"`
+	LimitNProc        Rlimit          `json:"limitNproc" yaml:"limitNproc" toml:"limitNproc"`
+	LimitCore         Rlimit          `json:"limitCore" yaml:"limitCore" toml:"limitCore"`
+	LimitAS           Rlimit          `json:"limitAs" yaml:"limitAs" toml:"limitAs"`
+	Nice              int             `json:"nice" yaml:"nice" toml:"nice"`
+	CPUAffinity       []int           `json:"cpuAffinity" yaml:"cpuAffinity" toml:"cpuAffinity"`
+	Umask             string          `json:"umask" yaml:"umask" toml:"umask"`
+	OOMScoreAdj       int             `json:"oomScoreAdj" yaml:"oomScoreAdj" toml:"oomScoreAdj"`
+	StopCmd           []string        `json:"stopCommand" yaml:"stopCommand" toml:"stopCommand"`
+	StopTime          Duration        `json:"stopTime" yaml:"stopTime" toml:"stopTime"`
+	StopSignal        string          `json:"stopSignal" yaml:"stopSignal" toml:"stopSignal"`
+	StopSignals       []StopStep      `json:"stopSignals" yaml:"stopSignals" toml:"stopSignals"`
+	ReloadCmd         []string        `json:"reloadCommand" yaml:"reloadCommand" toml:"reloadCommand"`
+	ReloadSignal      string          `json:"reloadSignal" yaml:"reloadSignal" toml:"reloadSignal"`
+	LogFile           string          `json:"logFile" yaml:"logFile" toml:"logFile"`
+	LogRotation       LogRotation     `json:"logRotation" yaml:"logRotation" toml:"logRotation"`
+	FailOnExit        bool            `json:"failOnExit" yaml:"failOnExit" toml:"failOnExit"`
+	CheckCmd          []string        `json:"check" yaml:"check" toml:"check"`
+	CheckIntvl        Duration        `json:"checkInterval" yaml:"checkInterval" toml:"checkInterval"`
+	CheckTime         Duration        `json:"checkTimeout" yaml:"checkTimeout" toml:"checkTimeout"`
+	CheckFails        int             `json:"checkFailures" yaml:"checkFailures" toml:"checkFailures"`
+	Probes            []ProbeManifest `json:"probes" yaml:"probes" toml:"probes"`
+	Restart           RestartMode     `json:"restart" yaml:"restart" toml:"restart"`
+	RestartAttempts   int             `json:"restartAttempts" yaml:"restartAttempts" toml:"restartAttempts"`
+	RestartDelay      Duration        `json:"restartDelay" yaml:"restartDelay" toml:"restartDelay"`
+	RestartMaxDelay   Duration        `json:"restartMaxDelay" yaml:"restartMaxDelay" toml:"restartMaxDelay"`
+	RestartMultiplier float64         `json:"restartMultiplier" yaml:"restartMultiplier" toml:"restartMultiplier"`
+	RestartJitter     float64         `json:"restartJitter" yaml:"restartJitter" toml:"restartJitter"`
+	RestartResetAfter Duration        `json:"restartResetAfter" yaml:"restartResetAfter" toml:"restartResetAfter"`
+	Provides          []string        `json:"provides" yaml:"provides" toml:"provides"`
+	Depends           []string        `json:"depends" yaml:"depends" toml:"depends"`
+	Conflicts         []string        `json:"conflicts" yaml:"conflicts" toml:"conflicts"`
+	Directory         string          `json:"directory" yaml:"directory" toml:"directory"`
+	Instances         []string        `json:"instances" yaml:"instances" toml:"instances"`
 }
 
 func NewProcessFromManifest(m ProcessManifest) *Service {
@@ -350,6 +982,20 @@ func NewProcessFromManifest(m ProcessManifest) *Service {
 	p.name = m.Name
 	p.desc = m.Description
 	p.directory = m.Directory
+	p.env = m.Env
+	p.envFiles = m.EnvFiles
+	p.cleanEnv = m.CleanEnv
+	p.user = m.User
+	p.group = m.Group
+	p.groups = m.Groups
+	p.limitNoFile = m.LimitNoFile
+	p.limitNProc = m.LimitNProc
+	p.limitCore = m.LimitCore
+	p.limitAS = m.LimitAS
+	p.nice = m.Nice
+	p.affinity = m.CPUAffinity
+	p.umask = m.Umask
+	p.oomScoreAdj = m.OOMScoreAdj
 	if len(m.Command) != 0 {
 		p.startCmd = exec.Command(m.Command[0], m.Command[1:]...)
 		p.startCmd.Dir = p.directory
@@ -362,21 +1008,139 @@ func NewProcessFromManifest(m ProcessManifest) *Service {
 		p.checkCmd = exec.Command(m.CheckCmd[0], m.CheckCmd[1:]...)
 		p.checkCmd.Dir = p.directory
 	}
-	p.stopTime = m.StopTime
+	if len(m.ReloadCmd) != 0 {
+		p.reloadCmd = exec.Command(m.ReloadCmd[0], m.ReloadCmd[1:]...)
+		p.reloadCmd.Dir = p.directory
+	}
+	p.reloadSignal = m.ReloadSignal
+	p.checkInterval = time.Duration(m.CheckIntvl)
+	p.checkTimeout = time.Duration(m.CheckTime)
+	p.checkFailures = m.CheckFails
+	for _, pm := range m.Probes {
+		pr, e := newProbe(pm, p.directory)
+		if e != nil {
+			// Validated by NewProcessFromJson, but the manifest
+			// may have been built by hand.  Make it obvious.
+			err := e
+			pr = &probe{
+				kind:      pm.Type,
+				interval:  DefaultCheckInterval,
+				successes: 1,
+				failures:  1,
+				run: func(*Process, *os.Process, time.Duration) error {
+					return err
+				},
+			}
+		}
+		p.probes = append(p.probes, pr)
+	}
+	p.stopTime = time.Duration(m.StopTime)
+	p.stopSignal = m.StopSignal
+	p.stopSignals = m.StopSignals
 	p.depends = m.Depends
 	p.conflicts = m.Conflicts
 	p.provides = m.Provides
 	p.failOnExit = m.FailOnExit
+	p.kind = m.Type
+	p.readyTimeout = time.Duration(m.ReadyTimeout)
 
 	s := NewService(p)
 	s.SetProperty(PropRestart, m.Restart)
+	s.SetProperty(PropLogRotation, m.LogRotation)
+	s.SetProperty(PropLogFile, m.LogFile)
+	if m.Schedule != "" {
+		sched, e := ParseSchedule(m.Schedule)
+		if e != nil {
+			// Validated by NewProcessFromJson; an empty cron
+			// schedule never fires, which beats running always.
+			sched = &cronSchedule{spec: m.Schedule}
+		}
+		s.SetProperty(PropSchedule, sched)
+	}
+
+	if m.RestartDelay == 0 {
+		m.RestartDelay = Duration(DefaultRestartDelay)
+	}
+	if m.RestartMaxDelay == 0 {
+		m.RestartMaxDelay = Duration(DefaultRestartMaxDelay)
+	}
+	if m.RestartMultiplier == 0 {
+		m.RestartMultiplier = DefaultRestartMultiplier
+	}
+	if m.RestartJitter == 0 {
+		m.RestartJitter = DefaultRestartJitter
+	}
+	if m.RestartResetAfter == 0 {
+		m.RestartResetAfter = Duration(DefaultRestartResetAfter)
+	}
+	s.SetProperty(PropRateLimit, 0)
+	s.SetProperty(PropRestartAttempts, m.RestartAttempts)
+	s.SetProperty(PropRestartDelay, time.Duration(m.RestartDelay))
+	s.SetProperty(PropRestartMaxDelay,
+		time.Duration(m.RestartMaxDelay))
+	s.SetProperty(PropRestartMultiplier, m.RestartMultiplier)
+	s.SetProperty(PropRestartJitter, m.RestartJitter)
+	s.SetProperty(PropRestartResetAfter,
+		time.Duration(m.RestartResetAfter))
 	return s
 }
 
-func NewProcessFromJson(r io.Reader) (*Service, error) {
+// DecodeManifest reads a JSON manifest, and validates it.  Unknown fields
+// are errors.  See DecodeManifestFormat for other formats.
+func DecodeManifest(r io.Reader) (ProcessManifest, error) {
 	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
 	var m ProcessManifest
 	if e := dec.Decode(&m); e != nil {
+		return m, e
+	}
+	return m, validateManifest(m)
+}
+
+// validateManifest checks the parts of a decoded manifest that the
+// decoders cannot check for themselves.
+func validateManifest(m ProcessManifest) error {
+	switch m.Type {
+	case "", ProcessSimple, ProcessNotify, ProcessOneshot:
+	default:
+		return fmt.Errorf("Unknown process type %q", m.Type)
+	}
+	for _, kv := range m.Env {
+		if strings.Index(kv, "=") < 1 {
+			return fmt.Errorf("Bad environment variable %q", kv)
+		}
+	}
+	if r := m.LogRotation; r.MaxSize < 0 || r.MaxAge < 0 || r.Keep < 0 {
+		return fmt.Errorf("Bad log rotation")
+	}
+	if e := validateStop(m); e != nil {
+		return e
+	}
+	if e := validateResources(m); e != nil {
+		return e
+	}
+	for _, pm := range m.Probes {
+		if _, e := newProbe(pm, m.Directory); e != nil {
+			return e
+		}
+	}
+	if m.Schedule != "" {
+		if _, e := ParseSchedule(m.Schedule); e != nil {
+			return e
+		}
+		// Only a oneshot process completes, so any other would
+		// still be running when the next run was due.
+		if m.Type != ProcessOneshot {
+			return fmt.Errorf("Schedule requires type %q",
+				ProcessOneshot)
+		}
+	}
+	return nil
+}
+
+func NewProcessFromJson(r io.Reader) (*Service, error) {
+	m, e := DecodeManifest(r)
+	if e != nil {
 		return nil, e
 	}
 	return NewProcessFromManifest(m), nil
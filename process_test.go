@@ -21,8 +21,21 @@
 package govisor
 
 import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 
@@ -121,3 +134,1539 @@ func TestProcessFromManifest(t *testing.T) {
 		m.Shutdown()
 	})
 }
+
+func TestProcessCheck(t *testing.T) {
+	Convey("Test process health checks", t, func() {
+		mydir, _ := os.Getwd()
+		exname := mydir + "/" + "process_test.sh"
+		m := NewManager("TestProcessCheck")
+		SetTestLogger(t, m)
+		Reset(func() {
+			m.Shutdown()
+		})
+
+		Convey("A passing check stays healthy", func() {
+			s1 := NewProcessFromManifest(ProcessManifest{
+				Name:       "ProcessCheck:pass",
+				Command:    []string{exname, "3600"},
+				CheckCmd:   []string{exname, "exit"},
+				CheckIntvl: Duration(time.Millisecond * 20),
+				CheckFails: 2,
+			})
+			So(s1, ShouldNotBeNil)
+			m.AddService(s1)
+			So(s1.Enable(), ShouldBeNil)
+			time.Sleep(time.Millisecond * 300)
+			So(s1.Failed(), ShouldBeFalse)
+			So(s1.Running(), ShouldBeTrue)
+		})
+
+		Convey("A failing check faults the process", func() {
+			s1 := NewProcessFromManifest(ProcessManifest{
+				Name:       "ProcessCheck:fail",
+				Command:    []string{exname, "3600"},
+				CheckCmd:   []string{exname, "fail"},
+				CheckIntvl: Duration(time.Millisecond * 20),
+				CheckFails: 2,
+			})
+			So(s1, ShouldNotBeNil)
+			m.AddService(s1)
+			So(s1.Enable(), ShouldBeNil)
+			time.Sleep(time.Millisecond * 300)
+			So(s1.Failed(), ShouldBeTrue)
+			So(s1.Running(), ShouldBeFalse)
+		})
+	})
+}
+
+func TestProcessProbes(t *testing.T) {
+	Convey("Test process network probes", t, func() {
+		mydir, _ := os.Getwd()
+		exname := mydir + "/" + "process_test.sh"
+		m := NewManager("TestProcessProbes")
+		SetTestLogger(t, m)
+		Reset(func() {
+			m.Shutdown()
+		})
+
+		Convey("A passing HTTP probe is reported healthy", func() {
+			srv := httptest.NewServer(http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					fmt.Fprintln(w, "all good")
+				}))
+			defer srv.Close()
+
+			s1 := NewProcessFromManifest(ProcessManifest{
+				Name:    "ProcessProbes:http",
+				Command: []string{exname, "3600"},
+				Probes: []ProbeManifest{{
+					Type:     ProbeHTTP,
+					URL:      srv.URL + "/healthz",
+					Body:     "good",
+					Interval: Duration(time.Millisecond * 20),
+				}},
+			})
+			m.AddService(s1)
+			So(s1.Enable(), ShouldBeNil)
+			time.Sleep(time.Millisecond * 300)
+			So(s1.Check(), ShouldBeNil)
+			So(s1.Failed(), ShouldBeFalse)
+			status, _ := s1.Status()
+			So(strings.Contains(status, "http ok"), ShouldBeTrue)
+		})
+
+		Convey("Readiness and recovery wait for SuccessThreshold", func() {
+			var lk sync.Mutex
+			reqs := 0
+			srv := httptest.NewServer(http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					lk.Lock()
+					reqs++
+					n := reqs
+					lk.Unlock()
+					if n <= 2 {
+						w.WriteHeader(http.StatusServiceUnavailable)
+					}
+				}))
+			defer srv.Close()
+
+			s1 := NewProcessFromManifest(ProcessManifest{
+				Name:    "ProcessProbes:threshold",
+				Command: []string{exname, "3600"},
+				Probes: []ProbeManifest{{
+					Type:             ProbeHTTP,
+					URL:              srv.URL,
+					Interval:         Duration(time.Millisecond * 200),
+					SuccessThreshold: 3,
+					FailureThreshold: 10,
+				}},
+			})
+			m.AddService(s1)
+			So(s1.Enable(), ShouldBeNil)
+			So(s1.Running(), ShouldBeTrue)
+			So(s1.Ready(), ShouldBeFalse)
+
+			p := s1.prov.(*Process)
+
+			// Two failures, then one pass, at 600ms.
+			time.Sleep(time.Millisecond * 700)
+			So(p.Health(), ShouldContainSubstring, "http failing 2/10")
+			So(s1.Ready(), ShouldBeFalse)
+
+			// The third pass, at 1000ms.
+			time.Sleep(time.Millisecond * 500)
+			So(p.Health(), ShouldContainSubstring, "http ok")
+			So(s1.Ready(), ShouldBeTrue)
+		})
+
+		Convey("A failing TCP probe faults the process", func() {
+			l, e := net.Listen("tcp", "127.0.0.1:0")
+			So(e, ShouldBeNil)
+			addr := l.Addr().String()
+			l.Close()
+
+			s1 := NewProcessFromManifest(ProcessManifest{
+				Name:    "ProcessProbes:tcp",
+				Command: []string{exname, "3600"},
+				Probes: []ProbeManifest{{
+					Type:             ProbeTCP,
+					Address:          addr,
+					Interval:         Duration(time.Millisecond * 20),
+					FailureThreshold: 2,
+				}},
+			})
+			m.AddService(s1)
+			So(s1.Enable(), ShouldBeNil)
+			time.Sleep(time.Millisecond * 300)
+			So(s1.Failed(), ShouldBeTrue)
+			So(s1.Running(), ShouldBeFalse)
+		})
+	})
+}
+
+func TestProcessRestartAlways(t *testing.T) {
+	Convey("Test restarting a process that exits cleanly", t, func() {
+		mydir, _ := os.Getwd()
+		exname := mydir + "/" + "process_test.sh"
+		m := NewManager("TestProcessRestartAlways")
+		SetTestLogger(t, m)
+		Reset(func() {
+			m.Shutdown()
+		})
+
+		var mode RestartMode
+		e := json.Unmarshal([]byte(`"always"`), &mode)
+		So(e, ShouldBeNil)
+		So(mode, ShouldEqual, RestartAlways)
+
+		s1 := NewProcessFromManifest(ProcessManifest{
+			Name:            "ProcessRestartAlways:S1",
+			Command:         []string{exname, "exit"},
+			Restart:         mode,
+			RestartDelay:    Duration(time.Hour),
+			RestartAttempts: 1,
+		})
+		m.AddService(s1)
+		So(s1.Enable(), ShouldBeNil)
+		time.Sleep(time.Second * 2)
+
+		// One immediate restart, then the limit is reached.
+		recs, _ := s1.GetLog(0)
+		starts := 0
+		for _, r := range recs {
+			if strings.Contains(r.Text, "Process id") {
+				starts++
+			}
+		}
+		So(starts, ShouldEqual, 2)
+		So(s1.Failed(), ShouldBeTrue)
+	})
+}
+
+func TestProcessNotify(t *testing.T) {
+	Convey("Test readiness notification", t, func() {
+		mydir, _ := os.Getwd()
+		exname := mydir + "/" + "process_test.sh"
+		m := NewManager("TestProcessNotify")
+		SetTestLogger(t, m)
+		Reset(func() {
+			m.Shutdown()
+		})
+
+		s1 := NewProcessFromManifest(ProcessManifest{
+			Name:    "ProcessNotify:S1",
+			Type:    ProcessNotify,
+			Command: []string{exname, "3600"},
+		})
+		s2 := NewProcessFromManifest(ProcessManifest{
+			Name:    "ProcessNotify:S2",
+			Command: []string{exname, "3600"},
+			Depends: []string{"ProcessNotify:S1"},
+		})
+		m.AddService(s1)
+		m.AddService(s2)
+		So(s2.Enable(), ShouldBeNil)
+		So(s1.Enable(), ShouldBeNil)
+		time.Sleep(time.Millisecond * 100)
+
+		So(s1.Running(), ShouldBeTrue)
+		So(s1.Ready(), ShouldBeFalse)
+		So(s2.Running(), ShouldBeFalse)
+
+		p := s1.prov.(*Process)
+		p.lock.Lock()
+		path := p.notifySock.path()
+		p.lock.Unlock()
+
+		conn, e := net.Dial("unixgram", path)
+		So(e, ShouldBeNil)
+		_, e = conn.Write([]byte("STATUS=warming up\nREADY=1\n"))
+		So(e, ShouldBeNil)
+		conn.Close()
+		time.Sleep(time.Millisecond * 100)
+
+		So(s1.Ready(), ShouldBeTrue)
+		So(s2.Running(), ShouldBeTrue)
+	})
+
+	Convey("Test readiness timeout", t, func() {
+		mydir, _ := os.Getwd()
+		exname := mydir + "/" + "process_test.sh"
+		m := NewManager("TestProcessNotifyTimeout")
+		SetTestLogger(t, m)
+		Reset(func() {
+			m.Shutdown()
+		})
+
+		s1 := NewProcessFromManifest(ProcessManifest{
+			Name:         "ProcessNotifyTimeout:S1",
+			Type:         ProcessNotify,
+			ReadyTimeout: Duration(time.Millisecond * 200),
+			Command:      []string{exname, "3600"},
+		})
+		m.AddService(s1)
+		So(s1.Enable(), ShouldBeNil)
+		time.Sleep(time.Millisecond * 500)
+
+		So(s1.Failed(), ShouldBeTrue)
+		So(s1.Ready(), ShouldBeFalse)
+	})
+
+	Convey("Test readiness notification as another user", t, func() {
+		if os.Geteuid() != 0 {
+			// Only root can change credentials.
+			return
+		}
+		if _, e := user.Lookup("nobody"); e != nil {
+			return
+		}
+		// The test binary sends the notification, but it must be
+		// somewhere that nobody can run it from.
+		dir, e := ioutil.TempDir("", "govisor")
+		So(e, ShouldBeNil)
+		So(os.Chmod(dir, 0755), ShouldBeNil)
+		exe, e := os.Executable()
+		So(e, ShouldBeNil)
+		b, e := ioutil.ReadFile(exe)
+		So(e, ShouldBeNil)
+		helper := filepath.Join(dir, "helper")
+		So(ioutil.WriteFile(helper, b, 0755), ShouldBeNil)
+
+		m := NewManager("TestProcessNotifyUser")
+		SetTestLogger(t, m)
+		Reset(func() {
+			m.Shutdown()
+			os.RemoveAll(dir)
+		})
+
+		s1 := NewProcessFromManifest(ProcessManifest{
+			Name:         "ProcessNotifyUser:S1",
+			Type:         ProcessNotify,
+			ReadyTimeout: Duration(time.Second * 5),
+			Directory:    "/",
+			User:         "nobody",
+			Env:          []string{"GOVISOR_TEST_HELPER=notify"},
+			Command:      []string{helper, "-test.run=TestHelperNotify"},
+		})
+		m.AddService(s1)
+		So(s1.Enable(), ShouldBeNil)
+		for i := 0; i < 50 && !s1.Ready(); i++ {
+			time.Sleep(time.Millisecond * 100)
+		}
+		So(s1.Ready(), ShouldBeTrue)
+		So(s1.Failed(), ShouldBeFalse)
+	})
+}
+
+// TestHelperNotify is not a real test.  It is run by TestProcessNotify in
+// a child process, to send a readiness notification.
+func TestHelperNotify(t *testing.T) {
+	if os.Getenv("GOVISOR_TEST_HELPER") != "notify" {
+		return
+	}
+	conn, e := net.Dial("unixgram", os.Getenv("NOTIFY_SOCKET"))
+	if e != nil {
+		fmt.Fprintln(os.Stderr, e)
+		os.Exit(1)
+	}
+	conn.Write([]byte("READY=1\n"))
+	conn.Close()
+	time.Sleep(time.Hour)
+}
+
+func TestProcessOneshot(t *testing.T) {
+	Convey("Test oneshot processes", t, func() {
+		mydir, _ := os.Getwd()
+		exname := mydir + "/" + "process_test.sh"
+		m := NewManager("TestProcessOneshot")
+		SetTestLogger(t, m)
+		Reset(func() {
+			m.Shutdown()
+		})
+
+		s1 := NewProcessFromManifest(ProcessManifest{
+			Name:    "ProcessOneshot:S1",
+			Type:    ProcessOneshot,
+			Command: []string{exname, "1"},
+		})
+		s2 := NewProcessFromManifest(ProcessManifest{
+			Name:    "ProcessOneshot:S2",
+			Command: []string{exname, "3600"},
+			Depends: []string{"ProcessOneshot:S1"},
+		})
+		m.AddService(s1)
+		m.AddService(s2)
+		So(s2.Enable(), ShouldBeNil)
+		So(s1.Enable(), ShouldBeNil)
+		time.Sleep(time.Millisecond * 100)
+
+		So(s1.Completed(), ShouldBeFalse)
+		So(s2.Running(), ShouldBeFalse)
+
+		time.Sleep(time.Millisecond * 1500)
+		So(s1.Completed(), ShouldBeTrue)
+		So(s1.Failed(), ShouldBeFalse)
+		So(s2.Running(), ShouldBeTrue)
+		status, _ := s1.Status()
+		So(status, ShouldEqual, "Completed")
+
+		Convey("Restart runs it again", func() {
+			So(s1.Restart(), ShouldBeNil)
+			time.Sleep(time.Millisecond * 100)
+			So(s1.Completed(), ShouldBeFalse)
+			So(s2.Running(), ShouldBeFalse)
+			time.Sleep(time.Millisecond * 1500)
+			So(s1.Completed(), ShouldBeTrue)
+			So(s2.Running(), ShouldBeTrue)
+		})
+	})
+
+	Convey("Test failing oneshot", t, func() {
+		mydir, _ := os.Getwd()
+		exname := mydir + "/" + "process_test.sh"
+		m := NewManager("TestProcessOneshotFail")
+		SetTestLogger(t, m)
+		Reset(func() {
+			m.Shutdown()
+		})
+
+		s1 := NewProcessFromManifest(ProcessManifest{
+			Name:    "ProcessOneshotFail:S1",
+			Type:    ProcessOneshot,
+			Command: []string{exname, "fail"},
+		})
+		m.AddService(s1)
+		So(s1.Enable(), ShouldBeNil)
+		time.Sleep(time.Millisecond * 200)
+		So(s1.Failed(), ShouldBeTrue)
+		So(s1.Completed(), ShouldBeFalse)
+	})
+}
+
+func TestProcessSchedule(t *testing.T) {
+	Convey("Test scheduled oneshot processes", t, func() {
+		mydir, _ := os.Getwd()
+		exname := mydir + "/" + "process_test.sh"
+		m := NewManager("TestProcessSchedule")
+		SetTestLogger(t, m)
+		m.StartMonitoring()
+		Reset(func() {
+			m.Shutdown()
+		})
+
+		s1 := NewProcessFromManifest(ProcessManifest{
+			Name:     "ProcessSchedule:S1",
+			Type:     ProcessOneshot,
+			Schedule: "@every 1s",
+			Command:  []string{exname, "exit"},
+		})
+		m.AddService(s1)
+		So(s1.Enable(), ShouldBeNil)
+		time.Sleep(time.Millisecond * 2800)
+
+		recs, _ := s1.GetLog(0)
+		starts := 0
+		for _, r := range recs {
+			if strings.Contains(r.Text, "Process id") {
+				starts++
+			}
+		}
+		So(starts, ShouldEqual, 2)
+		_, _, result := s1.LastRun()
+		So(result, ShouldEqual, "Completed")
+
+		Convey("Only oneshot processes may be scheduled", func() {
+			_, e := DecodeManifest(strings.NewReader(`{
+				"name": "ProcessSchedule:S2",
+				"schedule": "@every 1s",
+				"command": ["true"]
+			}`))
+			So(e, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestProcessReload(t *testing.T) {
+	Convey("Test reloading manifests", t, func() {
+		mydir, _ := os.Getwd()
+		exname := mydir + "/" + "process_test.sh"
+		dir, e := ioutil.TempDir("", "govisor")
+		So(e, ShouldBeNil)
+		m := NewManager("TestProcessReload")
+		SetTestLogger(t, m)
+		Reset(func() {
+			m.Shutdown()
+			os.RemoveAll(dir)
+		})
+		write := func(file, name, desc string) {
+			b, e := json.Marshal(&ProcessManifest{
+				Name:        name,
+				Description: desc,
+				Command:     []string{exname, "3600"},
+			})
+			So(e, ShouldBeNil)
+			e = ioutil.WriteFile(filepath.Join(dir, file), b, 0644)
+			So(e, ShouldBeNil)
+		}
+		find := func(name string) *Service {
+			for _, s := range m.FindServices(name) {
+				return s
+			}
+			return nil
+		}
+
+		So(m.Reload(), ShouldEqual, ErrNoServiceDir)
+
+		write("a.json", "ProcessReload:A", "a")
+		write("b.json", "ProcessReload:B", "b")
+		So(m.LoadServices(dir), ShouldBeNil)
+		So(m.RestoreState(true), ShouldBeNil)
+		a, b := find("ProcessReload:A"), find("ProcessReload:B")
+		So(a, ShouldNotBeNil)
+		So(b, ShouldNotBeNil)
+		So(a.Running(), ShouldBeTrue)
+		So(b.Running(), ShouldBeTrue)
+
+		write("b.json", "ProcessReload:B", "b changed")
+		write("c.json", "ProcessReload:C", "c")
+		e = ioutil.WriteFile(filepath.Join(dir, "d.json"),
+			[]byte("{bogus"), 0644)
+		So(e, ShouldBeNil)
+		So(m.Reload(), ShouldNotBeNil)
+
+		So(find("ProcessReload:A"), ShouldEqual, a)
+		So(a.Running(), ShouldBeTrue)
+		b2 := find("ProcessReload:B")
+		So(b2, ShouldNotEqual, b)
+		So(b2.Description(), ShouldEqual, "b changed")
+		So(b2.Running(), ShouldBeTrue)
+		So(find("ProcessReload:C").Running(), ShouldBeTrue)
+
+		So(os.Remove(filepath.Join(dir, "a.json")), ShouldBeNil)
+		So(os.Remove(filepath.Join(dir, "d.json")), ShouldBeNil)
+		So(m.Reload(), ShouldBeNil)
+		So(find("ProcessReload:A"), ShouldBeNil)
+		So(find("ProcessReload:B"), ShouldEqual, b2)
+	})
+}
+
+func TestProcessAutoReload(t *testing.T) {
+	Convey("Test automatic reload when manifests change", t, func() {
+		mydir, _ := os.Getwd()
+		exname := mydir + "/" + "process_test.sh"
+		dir, e := ioutil.TempDir("", "govisor")
+		So(e, ShouldBeNil)
+		m := NewManager("TestProcessAutoReload")
+		SetTestLogger(t, m)
+		Reset(func() {
+			m.Shutdown()
+			os.RemoveAll(dir)
+		})
+
+		So(m.StartAutoReload(), ShouldEqual, ErrNoServiceDir)
+		So(m.LoadServices(dir), ShouldBeNil)
+		So(m.RestoreState(true), ShouldBeNil)
+		if e := m.StartAutoReload(); e == ErrNotSupported {
+			return
+		} else {
+			So(e, ShouldBeNil)
+		}
+
+		// A partial file is ignored, and fixed up when complete.
+		fname := filepath.Join(dir, "a.json")
+		So(ioutil.WriteFile(fname, []byte(`{"name": "Proc`), 0644),
+			ShouldBeNil)
+		time.Sleep(time.Millisecond * 500)
+		So(len(m.FindServices("ProcessAutoReload:A")), ShouldEqual, 0)
+
+		b, e := json.Marshal(&ProcessManifest{
+			Name:    "ProcessAutoReload:A",
+			Command: []string{exname, "3600"},
+		})
+		So(e, ShouldBeNil)
+		So(ioutil.WriteFile(fname, b, 0644), ShouldBeNil)
+		time.Sleep(time.Millisecond * 500)
+		svcs := m.FindServices("ProcessAutoReload:A")
+		So(len(svcs), ShouldEqual, 1)
+		So(svcs[0].Running(), ShouldBeTrue)
+
+		So(os.Remove(fname), ShouldBeNil)
+		time.Sleep(time.Millisecond * 500)
+		So(len(m.FindServices("ProcessAutoReload:A")), ShouldEqual, 0)
+
+		m.StopAutoReload()
+		So(ioutil.WriteFile(fname, b, 0644), ShouldBeNil)
+		time.Sleep(time.Millisecond * 500)
+		So(len(m.FindServices("ProcessAutoReload:A")), ShouldEqual, 0)
+
+		// If the directory is replaced, then the new one is watched.
+		So(m.StartAutoReload(), ShouldBeNil)
+		So(os.Remove(fname), ShouldBeNil)
+		next := dir + ".new"
+		So(os.Mkdir(next, 0755), ShouldBeNil)
+		So(ioutil.WriteFile(filepath.Join(next, "a.json"), b, 0644),
+			ShouldBeNil)
+		So(os.RemoveAll(dir), ShouldBeNil)
+		So(os.Rename(next, dir), ShouldBeNil)
+		time.Sleep(rewatchDelay + time.Millisecond*500)
+		So(len(m.FindServices("ProcessAutoReload:A")), ShouldEqual, 1)
+		So(os.Remove(fname), ShouldBeNil)
+		time.Sleep(time.Millisecond * 500)
+		So(len(m.FindServices("ProcessAutoReload:A")), ShouldEqual, 0)
+
+		// A pending reload is not lost when watching stops.
+		So(ioutil.WriteFile(fname, b, 0644), ShouldBeNil)
+		time.Sleep(time.Millisecond * 50)
+		m.StopAutoReload()
+		time.Sleep(time.Millisecond * 500)
+		So(len(m.FindServices("ProcessAutoReload:A")), ShouldEqual, 1)
+	})
+}
+
+func TestCheckManifests(t *testing.T) {
+	Convey("Test checking manifests", t, func() {
+		mydir, _ := os.Getwd()
+		exname := mydir + "/" + "process_test.sh"
+		dir, e := ioutil.TempDir("", "govisor")
+		So(e, ShouldBeNil)
+		Reset(func() {
+			os.RemoveAll(dir)
+		})
+		write := func(file string, m ProcessManifest) {
+			if len(m.Command) == 0 {
+				m.Command = []string{exname}
+			}
+			b, e := json.Marshal(&m)
+			So(e, ShouldBeNil)
+			e = ioutil.WriteFile(filepath.Join(dir, file), b, 0644)
+			So(e, ShouldBeNil)
+		}
+
+		write("a.json", ProcessManifest{Name: "a", Provides: []string{"db"}})
+		write("b.json", ProcessManifest{Name: "b", Depends: []string{"db"}})
+		So(CheckManifests(dir), ShouldBeEmpty)
+
+		check := func(file string, text string) {
+			found := false
+			for _, e := range CheckManifests(dir) {
+				me := e.(*ManifestError)
+				if me.File == filepath.Join(dir, file) &&
+					strings.Contains(me.Error(), text) {
+					found = true
+				}
+			}
+			So(found, ShouldBeTrue)
+		}
+
+		Convey("Syntax errors have positions", func() {
+			e := ioutil.WriteFile(filepath.Join(dir, "c.json"),
+				[]byte("{\n  \"name\": \"c\",\n  \"command\" [\n"), 0644)
+			So(e, ShouldBeNil)
+			check("c.json", "c.json:3:13:")
+		})
+		Convey("Bad commands are found", func() {
+			write("c.json", ProcessManifest{Name: "c",
+				Command: []string{"/no/such/command"}})
+			check("c.json", "bad command")
+			write("c.json", ProcessManifest{Name: "c",
+				Command: []string{filepath.Join(dir, "a.json")}})
+			check("c.json", "not executable")
+			write("c.json", ProcessManifest{Name: "c",
+				Command: []string{""}})
+			check("c.json", "missing command")
+		})
+		Convey("Bad directories are found", func() {
+			write("c.json", ProcessManifest{Name: "c",
+				Directory: "/no/such/dir"})
+			check("c.json", "bad directory")
+		})
+		Convey("Duplicate names are found", func() {
+			write("c.json", ProcessManifest{Name: "a"})
+			check("c.json", ErrNameExists.Error())
+		})
+		Convey("Missing dependencies are found", func() {
+			write("c.json", ProcessManifest{Name: "c",
+				Depends: []string{"nothing"}})
+			check("c.json", "nothing provides dependency nothing")
+		})
+		Convey("Cycles are found", func() {
+			write("a.json", ProcessManifest{Name: "a",
+				Provides: []string{"db"}, Depends: []string{"c"}})
+			write("c.json", ProcessManifest{Name: "c",
+				Depends: []string{"b"}})
+			check("a.json", "dependency cycle: a -> c -> b -> a")
+			check("c.json", "dependency cycle")
+		})
+		Convey("Conflicting dependencies are found", func() {
+			write("c.json", ProcessManifest{Name: "c",
+				Depends: []string{"b"}, Conflicts: []string{"a"}})
+			check("c.json", "conflicts with dependency a")
+		})
+	})
+}
+
+func TestManifestFormats(t *testing.T) {
+	Convey("Test YAML and TOML manifests", t, func() {
+		dir, e := ioutil.TempDir("", "govisor")
+		So(e, ShouldBeNil)
+		Reset(func() {
+			os.RemoveAll(dir)
+		})
+		write := func(file, text string) string {
+			fname := filepath.Join(dir, file)
+			e := ioutil.WriteFile(fname, []byte(text), 0644)
+			So(e, ShouldBeNil)
+			return fname
+		}
+		expect := ProcessManifest{
+			Name:     "ManifestFormats:A",
+			Command:  []string{"/bin/sh", "-c", "true"},
+			StopTime: Duration(time.Second * 5),
+			Restart:  RestartOnFailure,
+			Depends:  []string{"db"},
+			Probes: []ProbeManifest{{
+				Type:    ProbeTCP,
+				Address: "localhost:5432",
+			}},
+		}
+
+		So(ManifestFormat("a.json"), ShouldEqual, FormatJSON)
+		So(ManifestFormat("a.YML"), ShouldEqual, FormatYAML)
+		So(ManifestFormat("a.toml"), ShouldEqual, FormatTOML)
+		So(ManifestFormat("a"), ShouldEqual, FormatJSON)
+
+		Convey("YAML manifests work", func() {
+			fname := write("a.yaml", `
+# A comment
+name: ManifestFormats:A
+command: [ /bin/sh, -c, "true" ]
+stopTime: 5s
+restart: true
+depends: [ db ]
+probes:
+  - type: tcp
+    address: localhost:5432
+`)
+			m, e := ReadManifest(fname)
+			So(e, ShouldBeNil)
+			So(m, ShouldResemble, expect)
+
+			write("b.yml", "name: b\nbogus: 1\n")
+			_, e = ReadManifest(filepath.Join(dir, "b.yml"))
+			So(e, ShouldNotBeNil)
+			So(e.Error(), ShouldContainSubstring, "bogus")
+		})
+		Convey("TOML manifests work", func() {
+			fname := write("a.toml", `
+# A comment
+name = "ManifestFormats:A"
+command = [ "/bin/sh", "-c", "true" ]
+stopTime = "5s"
+restart = "on-failure"
+depends = [ "db" ]
+
+[[probes]]
+type = "tcp"
+address = "localhost:5432"
+`)
+			m, e := ReadManifest(fname)
+			So(e, ShouldBeNil)
+			So(m, ShouldResemble, expect)
+
+			write("b.toml", "name = \"b\"\nbogus = 1\n")
+			_, e = ReadManifest(filepath.Join(dir, "b.toml"))
+			So(e, ShouldNotBeNil)
+			So(e.Error(), ShouldContainSubstring, "bogus")
+
+			write("b.toml", "name = \"b\"\ncommand = [\n")
+			errs := CheckManifests(dir)
+			So(len(errs), ShouldBeGreaterThan, 0)
+			So(errs[len(errs)-1].(*ManifestError).Line, ShouldEqual, 3)
+		})
+		Convey("JSON manifests reject unknown fields", func() {
+			fname := write("a.json", `{"name": "a", "bogus": 1}`)
+			_, e := ReadManifest(fname)
+			So(e, ShouldNotBeNil)
+			So(e.Error(), ShouldContainSubstring, "bogus")
+		})
+		Convey("Durations are numbers or strings in every format", func() {
+			want := ProcessManifest{
+				Name:         "d",
+				StopTime:     Duration(time.Second * 5),
+				RestartDelay: Duration(time.Second * 2),
+				StopSignals: []StopStep{
+					{Signal: "TERM", Wait: Duration(time.Second * 3)},
+					{Signal: "KILL"},
+				},
+				Probes: []ProbeManifest{{
+					Type:     ProbeTCP,
+					Address:  "localhost:1",
+					Interval: Duration(time.Second),
+				}},
+				LogRotation: LogRotation{MaxAge: Duration(time.Hour)},
+			}
+			for _, m := range []struct {
+				format string
+				text   string
+			}{
+				{FormatJSON, `{"name": "d", "stopTime": 5000000000,
+					"restartDelay": 2000000000,
+					"stopSignals": [{"signal": "TERM",
+					"wait": 3000000000}, {"signal": "KILL"}],
+					"probes": [{"type": "tcp",
+					"address": "localhost:1",
+					"interval": 1000000000}],
+					"logRotation": {"maxAge": 3600000000000}}`},
+				{FormatJSON, `{"name": "d", "stopTime": "5s",
+					"restartDelay": "2s",
+					"stopSignals": [{"signal": "TERM",
+					"wait": "3s"}, {"signal": "KILL"}],
+					"probes": [{"type": "tcp",
+					"address": "localhost:1", "interval": "1s"}],
+					"logRotation": {"maxAge": "1h"}}`},
+				{FormatYAML, `
+name: d
+stopTime: 5000000000
+restartDelay: 2000000000
+stopSignals: [{signal: TERM, wait: 3000000000}, {signal: KILL}]
+probes: [{type: tcp, address: "localhost:1", interval: 1000000000}]
+logRotation: {maxAge: 3600000000000}
+`},
+				{FormatYAML, `
+name: d
+stopTime: 5s
+restartDelay: 2s
+stopSignals: [{signal: TERM, wait: 3s}, {signal: KILL}]
+probes: [{type: tcp, address: "localhost:1", interval: 1s}]
+logRotation: {maxAge: 1h}
+`},
+				{FormatTOML, `
+name = "d"
+stopTime = 5000000000
+restartDelay = 2000000000
+stopSignals = [{signal = "TERM", wait = 3000000000}, {signal = "KILL"}]
+probes = [{type = "tcp", address = "localhost:1", interval = 1000000000}]
+logRotation = {maxAge = 3600000000000}
+`},
+				{FormatTOML, `
+name = "d"
+stopTime = "5s"
+restartDelay = "2s"
+stopSignals = [{signal = "TERM", wait = "3s"}, {signal = "KILL"}]
+probes = [{type = "tcp", address = "localhost:1", interval = "1s"}]
+logRotation = {maxAge = "1h"}
+`},
+			} {
+				mf, e := DecodeManifestFormat(
+					strings.NewReader(m.text), m.format)
+				So(e, ShouldBeNil)
+				So(mf, ShouldResemble, want)
+			}
+			for _, bad := range []string{
+				`{"name": "d", "stopTime": "5 fortnights"}`,
+				`{"name": "d", "stopTime": 1.5}`,
+				`{"name": "d", "stopTime": true}`,
+			} {
+				_, e := DecodeManifestFormat(strings.NewReader(bad),
+					FormatJSON)
+				So(e, ShouldNotBeNil)
+			}
+		})
+	})
+}
+
+func TestProcessTemplates(t *testing.T) {
+	Convey("Test templated services", t, func() {
+		mydir, _ := os.Getwd()
+		exname := mydir + "/" + "process_test.sh"
+		dir, e := ioutil.TempDir("", "govisor")
+		So(e, ShouldBeNil)
+		m := NewManager("TestProcessTemplates")
+		SetTestLogger(t, m)
+		m.SetStateFile(filepath.Join(dir, "state.json"))
+		Reset(func() {
+			m.Shutdown()
+			os.RemoveAll(dir)
+		})
+		svcDir := filepath.Join(dir, "services")
+		So(os.Mkdir(svcDir, 0755), ShouldBeNil)
+		b, e := json.Marshal(&ProcessManifest{
+			Description: "worker %i",
+			Command:     []string{exname, "3600"},
+			Env:         []string{"WORKER=%n"},
+			Depends:     []string{"queue:%i"},
+			Instances:   []string{"1", "2"},
+		})
+		So(e, ShouldBeNil)
+		e = ioutil.WriteFile(filepath.Join(svcDir, "worker@.json"), b, 0644)
+		So(e, ShouldBeNil)
+
+		So(m.LoadServices(svcDir), ShouldBeNil)
+		So(m.RestoreState(false), ShouldBeNil)
+		So(len(m.FindServices("worker")), ShouldEqual, 2)
+		w1 := m.FindServices("worker:1")
+		So(len(w1), ShouldEqual, 1)
+		So(w1[0].Description(), ShouldEqual, "worker 1")
+		So(w1[0].Depends(), ShouldResemble, []string{"queue:1"})
+
+		_, e = m.Instantiate("nothing@1")
+		So(e, ShouldEqual, ErrNoTemplate)
+		_, e = m.Instantiate("worker@")
+		So(e, ShouldEqual, ErrBadInstance)
+		_, e = m.Instantiate("worker@1")
+		So(e, ShouldEqual, ErrNameExists)
+		s3, e := m.Instantiate("worker@3")
+		So(e, ShouldBeNil)
+		So(s3.Name(), ShouldEqual, "worker:3")
+		So(len(m.FindServices("worker")), ShouldEqual, 3)
+
+		// Dynamic instances survive reloads, and are saved.
+		So(m.Reload(), ShouldBeNil)
+		So(len(m.FindServices("worker:3")), ShouldEqual, 1)
+		state, e := ioutil.ReadFile(filepath.Join(dir, "state.json"))
+		So(e, ShouldBeNil)
+		So(string(state), ShouldContainSubstring, `"worker": [`)
+
+		m2 := NewManager("TestProcessTemplates2")
+		SetTestLogger(t, m2)
+		defer m2.Shutdown()
+		So(m2.LoadServices(svcDir), ShouldBeNil)
+		m2.SetStateFile(filepath.Join(dir, "state.json"))
+		So(m2.RestoreState(false), ShouldBeNil)
+		So(len(m2.FindServices("worker:3")), ShouldEqual, 1)
+
+		So(m.Uninstantiate("worker@1"), ShouldEqual, ErrNoInstance)
+		So(m.Uninstantiate("worker@3"), ShouldBeNil)
+		So(len(m.FindServices("worker:3")), ShouldEqual, 0)
+		So(len(m.FindServices("worker")), ShouldEqual, 2)
+	})
+}
+
+func TestProcessEnvironment(t *testing.T) {
+	Convey("Test process environment", t, func() {
+		mydir, _ := os.Getwd()
+		exname := mydir + "/" + "process_test.sh"
+		dir, e := ioutil.TempDir("", "govisor")
+		So(e, ShouldBeNil)
+		m := NewManager("TestProcessEnvironment")
+		SetTestLogger(t, m)
+		Reset(func() {
+			m.Shutdown()
+			os.RemoveAll(dir)
+		})
+		envFile := filepath.Join(dir, "env")
+		e = ioutil.WriteFile(envFile, []byte(`
+# A comment
+export GREETING=hello # trailing comment
+QUOTED="a \"b\" ${GREETING}"
+LITERAL='${GREETING}'
+WORKDIR=/usr
+`), 0644)
+		So(e, ShouldBeNil)
+
+		// run returns the status of a oneshot process once it is done.
+		run := func(mf ProcessManifest) string {
+			mf.Name = "ProcessEnvironment:S1"
+			mf.Type = ProcessOneshot
+			s := NewProcessFromManifest(mf)
+			So(m.AddService(s), ShouldBeNil)
+			So(s.Enable(), ShouldBeNil)
+			time.Sleep(time.Millisecond * 200)
+			status := "Failed"
+			if s.Completed() {
+				status = "Completed"
+			}
+			s.Disable()
+			So(m.DeleteService(s), ShouldBeNil)
+			return status
+		}
+
+		Convey("Environment files are loaded", func() {
+			mf := ProcessManifest{
+				EnvFiles: []string{envFile, "-/no/such/file"},
+				Env:      []string{"WHO=${GREETING} world"},
+			}
+			mf.Command = []string{exname, "checkenv", "WHO",
+				"hello world"}
+			So(run(mf), ShouldEqual, "Completed")
+			mf.Command = []string{exname, "checkenv", "QUOTED",
+				`a "b" hello`}
+			So(run(mf), ShouldEqual, "Completed")
+			mf.Command = []string{exname, "checkenv", "LITERAL",
+				"$${GREETING}"}
+			So(run(mf), ShouldEqual, "Completed")
+		})
+		Convey("Commands and directories are expanded", func() {
+			status := run(ProcessManifest{
+				EnvFiles:  []string{envFile},
+				Directory: "${WORKDIR}",
+				Command:   []string{exname, "checkwd", "${WORKDIR}"},
+			})
+			So(status, ShouldEqual, "Completed")
+		})
+		Convey("Missing environment files fail", func() {
+			status := run(ProcessManifest{
+				EnvFiles: []string{"/no/such/file"},
+				Command:  []string{exname, "exit"},
+			})
+			So(status, ShouldEqual, "Failed")
+		})
+		Convey("Clean environments are clean", func() {
+			So(os.Getenv("HOME"), ShouldNotBeEmpty)
+			status := run(ProcessManifest{
+				CleanEnv: true,
+				Command:  []string{exname, "checkenv", "HOME", ""},
+			})
+			So(status, ShouldEqual, "Completed")
+		})
+		Convey("Bad variables are rejected", func() {
+			_, e := DecodeManifest(strings.NewReader(
+				`{"name": "x", "env": ["NOEQUALS"]}`))
+			So(e, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestProcessCredentials(t *testing.T) {
+	Convey("Test running processes as another user", t, func() {
+		if os.Geteuid() != 0 {
+			// Only root can change credentials.
+			return
+		}
+		u, e := user.Lookup("nobody")
+		if e != nil {
+			return
+		}
+		m := NewManager("TestProcessCredentials")
+		SetTestLogger(t, m)
+		Reset(func() {
+			m.Shutdown()
+		})
+
+		script := fmt.Sprintf(`test "$(id -u)" = %s && `+
+			`test "$(id -g)" = 0 && test "$USER" = nobody && `+
+			`test "$HOME" = "%s" && id -G | grep -qw 1`, u.Uid, u.HomeDir)
+		s1 := NewProcessFromManifest(ProcessManifest{
+			Name:      "ProcessCredentials:S1",
+			Type:      ProcessOneshot,
+			Directory: "/",
+			Command:   []string{"/bin/sh", "-c", script},
+			User:      "nobody",
+			Group:     "0",
+			Groups:    []string{"1"},
+		})
+		m.AddService(s1)
+		So(s1.Enable(), ShouldBeNil)
+		time.Sleep(time.Millisecond * 200)
+		So(s1.Completed(), ShouldBeTrue)
+
+		v, e := s1.GetProperty(PropProcessUser)
+		So(e, ShouldBeNil)
+		So(v, ShouldEqual, "nobody")
+
+		s2 := NewProcessFromManifest(ProcessManifest{
+			Name:    "ProcessCredentials:S2",
+			Command: []string{"/bin/true"},
+			User:    "no-such-user-here",
+		})
+		m.AddService(s2)
+		So(s2.Enable(), ShouldBeNil)
+		time.Sleep(time.Millisecond * 100)
+		So(s2.Failed(), ShouldBeTrue)
+	})
+}
+
+func TestProcessResources(t *testing.T) {
+	Convey("Test process resource controls", t, func() {
+		m, e := DecodeManifest(strings.NewReader(`{
+			"name": "x", "limitNofile": 512, "limitCore": "0:unlimited",
+			"nice": 5, "umask": "027", "oomScoreAdj": 500 }`))
+		So(e, ShouldBeNil)
+		So(m.LimitNoFile, ShouldEqual, Rlimit("512"))
+		So(m.LimitCore, ShouldEqual, Rlimit("0:unlimited"))
+		cur, max, e := m.LimitCore.Values()
+		So(e, ShouldBeNil)
+		So(cur, ShouldEqual, 0)
+		So(max, ShouldEqual, RlimitInfinity)
+
+		for _, bad := range []string{
+			`{"limitNofile": -1}`,
+			`{"limitNofile": "10:5"}`,
+			`{"limitAs": "lots"}`,
+			`{"nice": 20}`,
+			`{"umask": "0999"}`,
+			`{"oomScoreAdj": 1001}`,
+			`{"cpuAffinity": [-1]}`,
+		} {
+			_, e := DecodeManifest(strings.NewReader(bad))
+			So(e, ShouldNotBeNil)
+		}
+
+		if runtime.GOOS != "linux" {
+			return
+		}
+		mgr := NewManager("TestProcessResources")
+		SetTestLogger(t, mgr)
+		Reset(func() {
+			mgr.Shutdown()
+		})
+
+		// The limits are in place before the command runs.
+		script := `test "$(ulimit -n)" = 512 && ` +
+			`test "$(ulimit -c)" = 0 && test "$(umask)" = 0027 && ` +
+			`test "$(cat /proc/self/oom_score_adj)" = 500 && ` +
+			`test "$(cut -d' ' -f19 /proc/$$/stat)" = 5 && ` +
+			`grep -q 'Cpus_allowed_list:.0$' /proc/$$/status`
+		m.Name = "ProcessResources:S1"
+		m.Type = ProcessOneshot
+		m.CPUAffinity = []int{0}
+		m.Command = []string{"/bin/sh", "-c", script}
+		s1 := NewProcessFromManifest(m)
+		mgr.AddService(s1)
+		So(s1.Enable(), ShouldBeNil)
+		time.Sleep(time.Millisecond * 600)
+		So(s1.Completed(), ShouldBeTrue)
+
+		v, e := s1.GetProperty(PropProcessLimitNoFile)
+		So(e, ShouldBeNil)
+		So(v, ShouldEqual, Rlimit("512"))
+		v, e = s1.GetProperty(PropProcessOOMScoreAdj)
+		So(e, ShouldBeNil)
+		So(v, ShouldEqual, 500)
+		v, e = s1.GetProperty(PropProcessCPUAffinity)
+		So(e, ShouldBeNil)
+		So(v, ShouldResemble, []int{0})
+		So(s1.SetProperty(PropProcessNice, 42), ShouldEqual, ErrBadPropValue)
+		So(s1.SetProperty(PropProcessUmask, "8"), ShouldEqual, ErrBadPropValue)
+
+		// Our own umask is left alone.
+		mask := syscall.Umask(022)
+		syscall.Umask(mask)
+		So(mask, ShouldNotEqual, 027)
+
+		Convey("Privileged controls apply to other users", func() {
+			if os.Geteuid() != 0 {
+				return
+			}
+			u, e := user.Lookup("nobody")
+			if e != nil {
+				return
+			}
+			var rl syscall.Rlimit
+			So(syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl), ShouldBeNil)
+			script := fmt.Sprintf(`test "$(id -u)" = %s && `+
+				`test "$(ulimit -Hn)" = %d && `+
+				`test "$(cut -d' ' -f19 /proc/$$/stat)" = -5`,
+				u.Uid, rl.Max)
+			s2 := NewProcessFromManifest(ProcessManifest{
+				Name:        "ProcessResources:S2",
+				Type:        ProcessOneshot,
+				Directory:   "/",
+				User:        "nobody",
+				LimitNoFile: Rlimit(fmt.Sprint(rl.Max)),
+				Nice:        -5,
+				Command:     []string{"/bin/sh", "-c", script},
+			})
+			mgr.AddService(s2)
+			So(s2.Enable(), ShouldBeNil)
+			time.Sleep(time.Millisecond * 300)
+			So(s2.Completed(), ShouldBeTrue)
+		})
+
+		Convey("Launch failures are reported", func() {
+			s3 := NewProcessFromManifest(ProcessManifest{
+				Name:    "ProcessResources:S3",
+				Type:    ProcessOneshot,
+				Nice:    5,
+				Command: []string{"/no/such/command"},
+			})
+			mgr.AddService(s3)
+			So(s3.Enable(), ShouldBeNil)
+			So(s3.Failed(), ShouldBeTrue)
+		})
+
+		Convey("Resource controls need the launcher", func() {
+			launcherReady = false
+			Reset(func() {
+				launcherReady = true
+			})
+			s4 := NewProcessFromManifest(ProcessManifest{
+				Name:    "ProcessResources:S4",
+				Type:    ProcessOneshot,
+				Nice:    5,
+				Command: []string{"true"},
+			})
+			mgr.AddService(s4)
+			So(s4.Enable(), ShouldBeNil)
+			So(s4.Failed(), ShouldBeTrue)
+		})
+	})
+}
+
+// running returns true if the process exists, and is not a zombie.
+func running(pid string) bool {
+	b, e := ioutil.ReadFile("/proc/" + strings.TrimSpace(pid) + "/stat")
+	if e != nil {
+		return false
+	}
+	s := string(b)
+	return !strings.HasPrefix(s[strings.LastIndex(s, ")")+1:], " Z")
+}
+
+func TestProcessGroup(t *testing.T) {
+	Convey("Test that descendants are stopped with the process", t, func() {
+		if runtime.GOOS != "linux" {
+			return
+		}
+		dir, e := ioutil.TempDir("", "govisor")
+		So(e, ShouldBeNil)
+		m := NewManager("TestProcessGroup")
+		SetTestLogger(t, m)
+		Reset(func() {
+			m.Shutdown()
+			os.RemoveAll(dir)
+		})
+
+		pidFile := filepath.Join(dir, "pid")
+		s1 := NewProcessFromManifest(ProcessManifest{
+			Name: "ProcessGroup:S1",
+			Command: []string{"/bin/sh", "-c",
+				"sleep 3600 & echo $! > " + pidFile + "; wait"},
+		})
+		m.AddService(s1)
+		So(s1.Enable(), ShouldBeNil)
+		time.Sleep(time.Millisecond * 200)
+		pid, e := ioutil.ReadFile(pidFile)
+		So(e, ShouldBeNil)
+		So(running(string(pid)), ShouldBeTrue)
+		So(s1.Disable(), ShouldBeNil)
+		time.Sleep(time.Millisecond * 100)
+		So(running(string(pid)), ShouldBeFalse)
+
+		Convey("Leftovers are killed when the process exits", func() {
+			s2 := NewProcessFromManifest(ProcessManifest{
+				Name: "ProcessGroup:S2",
+				Type: ProcessOneshot,
+				Command: []string{"/bin/sh", "-c",
+					"sleep 3600 & echo $! > " + pidFile},
+			})
+			m.AddService(s2)
+			So(s2.Enable(), ShouldBeNil)
+			time.Sleep(time.Millisecond * 200)
+			So(s2.Completed(), ShouldBeTrue)
+			pid, e := ioutil.ReadFile(pidFile)
+			So(e, ShouldBeNil)
+			So(running(string(pid)), ShouldBeFalse)
+		})
+
+		Convey("Orphans are reaped by a subreaper", func() {
+			So(SetSubreaper(), ShouldBeNil)
+			s3 := NewProcessFromManifest(ProcessManifest{
+				Name: "ProcessGroup:S3",
+				Command: []string{"/bin/sh", "-c",
+					"(sleep 0.2 & echo $! > " + pidFile +
+						"); sleep 3600"},
+			})
+			m.AddService(s3)
+			So(s3.Enable(), ShouldBeNil)
+			time.Sleep(time.Millisecond * 100)
+			pid, e := ioutil.ReadFile(pidFile)
+			So(e, ShouldBeNil)
+			So(running(string(pid)), ShouldBeTrue)
+			time.Sleep(time.Millisecond * 400)
+			_, e = os.Stat("/proc/" + strings.TrimSpace(string(pid)))
+			So(os.IsNotExist(e), ShouldBeTrue)
+			So(s3.Running(), ShouldBeTrue)
+		})
+
+		Convey("Descendants that leave the group are stopped", func() {
+			s4 := NewProcessFromManifest(ProcessManifest{
+				Name: "ProcessGroup:S4",
+				Command: []string{"/bin/sh", "-c",
+					"setsid sleep 3600 & echo $! > " + pidFile +
+						"; wait"},
+			})
+			m.AddService(s4)
+			So(s4.Enable(), ShouldBeNil)
+			time.Sleep(time.Millisecond * 200)
+			pid, e := ioutil.ReadFile(pidFile)
+			So(e, ShouldBeNil)
+			So(running(string(pid)), ShouldBeTrue)
+			So(s4.Disable(), ShouldBeNil)
+			time.Sleep(time.Millisecond * 100)
+			So(running(string(pid)), ShouldBeFalse)
+		})
+
+		Convey("Orphans that leave the group are killed", func() {
+			So(SetSubreaper(), ShouldBeNil)
+			s5 := NewProcessFromManifest(ProcessManifest{
+				Name: "ProcessGroup:S5",
+				Type: ProcessOneshot,
+				Command: []string{"/bin/sh", "-c",
+					"setsid sleep 3600 & echo $! > " + pidFile},
+			})
+			m.AddService(s5)
+			So(s5.Enable(), ShouldBeNil)
+			time.Sleep(time.Millisecond * 200)
+			So(s5.Completed(), ShouldBeTrue)
+			pid, e := ioutil.ReadFile(pidFile)
+			So(e, ShouldBeNil)
+			So(running(string(pid)), ShouldBeFalse)
+		})
+
+		Convey("Descendants of a reaped pid are not found", func() {
+			cmd := exec.Command("/bin/sh", "-c", "sleep 3600 & wait")
+			So(cmd.Start(), ShouldBeNil)
+			time.Sleep(time.Millisecond * 100)
+			ss := findStragglers(cmd.Process.Pid, "none")
+			Reset(func() {
+				signalStragglers(ss, 0, syscall.SIGKILL)
+				cmd.Process.Kill()
+				cmd.Wait()
+			})
+			So(len(ss), ShouldEqual, 1)
+			So(len(findStragglers(0, "none")), ShouldEqual, 0)
+		})
+	})
+}
+
+func TestProcessStopSignals(t *testing.T) {
+	Convey("Test stop signals and escalation", t, func() {
+		mf, e := DecodeManifestFormat(strings.NewReader(`
+name: x
+stopSignals:
+  - signal: SIGQUIT
+    wait: 5s
+  - signal: TERM
+    wait: 10s
+  - signal: KILL
+`), FormatYAML)
+		So(e, ShouldBeNil)
+		So(mf.StopSignals, ShouldResemble, []StopStep{
+			{Signal: "SIGQUIT", Wait: Duration(time.Second * 5)},
+			{Signal: "TERM", Wait: Duration(time.Second * 10)},
+			{Signal: "KILL"},
+		})
+
+		for _, bad := range []string{
+			`{"stopSignal": "SIGNOPE"}`,
+			`{"stopSignal": "INT", "stopCommand": ["true"]}`,
+			`{"stopSignals": [{"signal": "INT"}, {"signal": "KILL"}]}`,
+			`{"stopSignals": [{"signal": "KILL"}], "stopSignal": "INT"}`,
+			`{"stopSignals": [{"signal": "KILL"}],
+				"reloadSignal": "SIGBOGUS"}`,
+			`{"stopSignals": [{"signal": "KILL"}],
+				"reloadSignal": "HUP", "reloadCommand": ["true"]}`,
+		} {
+			_, e := DecodeManifest(strings.NewReader(bad))
+			So(e, ShouldNotBeNil)
+		}
+
+		sig, e := ParseSignal("int")
+		So(e, ShouldBeNil)
+		So(sig, ShouldEqual, syscall.SIGINT)
+		So(SignalName(sig), ShouldEqual, "SIGINT")
+
+		if runtime.GOOS == "windows" {
+			return
+		}
+		m := NewManager("TestProcessStopSignals")
+		SetTestLogger(t, m)
+		Reset(func() {
+			m.Shutdown()
+		})
+		logged := func(s *Service) string {
+			recs, _ := s.GetLog(0)
+			var b strings.Builder
+			for _, r := range recs {
+				b.WriteString(r.Text + "\n")
+			}
+			return b.String()
+		}
+
+		s1 := NewProcessFromManifest(ProcessManifest{
+			Name:       "ProcessStopSignals:S1",
+			Command:    []string{"/bin/sleep", "3600"},
+			StopSignal: "SIGINT",
+			StopTime:   Duration(time.Second * 5),
+		})
+		m.AddService(s1)
+		So(s1.Enable(), ShouldBeNil)
+		time.Sleep(time.Millisecond * 100)
+		So(s1.Disable(), ShouldBeNil)
+		So(logged(s1), ShouldContainSubstring, "Sending SIGINT")
+		So(logged(s1), ShouldNotContainSubstring, "SIGKILL")
+
+		s2 := NewProcessFromManifest(ProcessManifest{
+			Name: "ProcessStopSignals:S2",
+			Command: []string{"/bin/sh", "-c",
+				`trap "" INT TERM; exec sleep 3600`},
+			StopSignals: []StopStep{
+				{Signal: "SIGINT", Wait: Duration(time.Millisecond * 200)},
+				{Signal: "SIGTERM", Wait: Duration(time.Millisecond * 200)},
+				{Signal: "SIGKILL"},
+			},
+		})
+		m.AddService(s2)
+		So(s2.Enable(), ShouldBeNil)
+		time.Sleep(time.Millisecond * 100)
+		now := time.Now()
+		So(s2.Disable(), ShouldBeNil)
+		So(time.Since(now), ShouldBeGreaterThan, time.Millisecond*400)
+		So(logged(s2), ShouldContainSubstring, "Sending SIGINT")
+		So(logged(s2), ShouldContainSubstring, "Sending SIGTERM")
+		So(logged(s2), ShouldContainSubstring, "Sending SIGKILL")
+		So(s2.Running(), ShouldBeFalse)
+	})
+}
+
+func TestServiceReload(t *testing.T) {
+	Convey("Test reloading a service without restarting it", t, func() {
+		if runtime.GOOS == "windows" {
+			return
+		}
+		dir, e := ioutil.TempDir("", "govisor")
+		So(e, ShouldBeNil)
+		m := NewManager("TestServiceReload")
+		SetTestLogger(t, m)
+		Reset(func() {
+			m.Shutdown()
+			os.RemoveAll(dir)
+		})
+		mark := filepath.Join(dir, "mark")
+
+		s1 := NewProcessFromManifest(ProcessManifest{
+			Name: "ServiceReload:S1",
+			Command: []string{"/bin/sh", "-c", "trap 'echo $$ > " +
+				mark + "' USR1; while :; do sleep 0.05; done"},
+			ReloadSignal: "SIGUSR1",
+		})
+		m.AddService(s1)
+		So(s1.Reload(), ShouldEqual, ErrNotRunning)
+		So(s1.Enable(), ShouldBeNil)
+		time.Sleep(time.Millisecond * 100)
+		So(s1.Reload(), ShouldBeNil)
+		time.Sleep(time.Millisecond * 200)
+		b, e := ioutil.ReadFile(mark)
+		So(e, ShouldBeNil)
+		So(s1.Running(), ShouldBeTrue)
+
+		s2 := NewProcessFromManifest(ProcessManifest{
+			Name:      "ServiceReload:S2",
+			Command:   []string{"/bin/sleep", "3600"},
+			ReloadCmd: []string{"/bin/sh", "-c", "echo $PID > " + mark},
+		})
+		m.AddService(s2)
+		So(s2.Enable(), ShouldBeNil)
+		time.Sleep(time.Millisecond * 100)
+		So(s2.Reload(), ShouldBeNil)
+		b2, e := ioutil.ReadFile(mark)
+		So(e, ShouldBeNil)
+		So(string(b2), ShouldNotEqual, string(b))
+		So(s2.Running(), ShouldBeTrue)
+
+		// A slow reload command does not hold up the process.
+		s3 := NewProcessFromManifest(ProcessManifest{
+			Name:      "ServiceReload:S3",
+			Command:   []string{"/bin/sleep", "3600"},
+			ReloadCmd: []string{"/bin/sleep", "1"},
+		})
+		m.AddService(s3)
+		So(s3.Enable(), ShouldBeNil)
+		time.Sleep(time.Millisecond * 100)
+		done := make(chan error, 1)
+		go func() {
+			done <- s3.Reload()
+		}()
+		time.Sleep(time.Millisecond * 200)
+		start := time.Now()
+		So(s3.Signal(syscall.Signal(0), false), ShouldBeNil)
+		So(time.Since(start), ShouldBeLessThan, time.Millisecond*500)
+		So(<-done, ShouldBeNil)
+
+		_, e = DecodeManifest(strings.NewReader(
+			`{"reloadSignal": "HUP", "reloadCommand": ["true"]}`))
+		So(e, ShouldNotBeNil)
+	})
+}
+
+func TestServiceSignal(t *testing.T) {
+	Convey("Test sending signals to a service", t, func() {
+		if runtime.GOOS != "linux" {
+			return
+		}
+		dir, e := ioutil.TempDir("", "govisor")
+		So(e, ShouldBeNil)
+		m := NewManager("TestServiceSignal")
+		SetTestLogger(t, m)
+		Reset(func() {
+			m.Shutdown()
+			os.RemoveAll(dir)
+		})
+		mark := filepath.Join(dir, "mark")
+		pidFile := filepath.Join(dir, "pid")
+
+		s1 := NewProcessFromManifest(ProcessManifest{
+			Name: "ServiceSignal:S1",
+			Command: []string{"/bin/sh", "-c", "sleep 3600 & echo $! > " +
+				pidFile + "; trap 'echo yes > " + mark +
+				"' USR2; while :; do sleep 0.05; done"},
+		})
+		m.AddService(s1)
+		So(s1.Signal(syscall.SIGUSR2, false), ShouldEqual, ErrNotRunning)
+		So(s1.Enable(), ShouldBeNil)
+		time.Sleep(time.Millisecond * 100)
+		So(s1.Signal(syscall.SIGUSR2, false), ShouldBeNil)
+		time.Sleep(time.Millisecond * 200)
+		_, e = os.Stat(mark)
+		So(e, ShouldBeNil)
+		So(s1.Running(), ShouldBeTrue)
+
+		pid, e := ioutil.ReadFile(pidFile)
+		So(e, ShouldBeNil)
+		So(running(string(pid)), ShouldBeTrue)
+		So(s1.Signal(syscall.SIGTERM, true), ShouldBeNil)
+		time.Sleep(time.Millisecond * 200)
+		So(running(string(pid)), ShouldBeFalse)
+		So(s1.Running(), ShouldBeFalse)
+	})
+}
+
+func TestProcessLogRecords(t *testing.T) {
+	Convey("Test structured log records", t, func() {
+		mydir, _ := os.Getwd()
+		exname := mydir + "/" + "process_test.sh"
+		m := NewManager("TestProcessLogRecords")
+		SetTestLogger(t, m)
+		Reset(func() {
+			m.Shutdown()
+		})
+
+		s1 := NewProcessFromManifest(ProcessManifest{
+			Name:    "ProcessLogRecords:S1",
+			Command: []string{exname, "5"},
+		})
+		s2 := NewProcessFromManifest(ProcessManifest{
+			Name:    "ProcessLogRecords:S2",
+			Command: []string{mydir + "/does-not-exist"},
+		})
+		m.AddService(s1)
+		m.AddService(s2)
+		So(s1.Enable(), ShouldBeNil)
+		So(s2.Enable(), ShouldBeNil)
+		time.Sleep(time.Millisecond * 500)
+
+		recs, _ := s1.GetLog(0)
+		pid := 0
+		streams := make(map[string]LogRecord)
+		for _, r := range recs {
+			So(r.Service, ShouldEqual, "ProcessLogRecords:S1")
+			if strings.HasPrefix(r.Text, "Process id ") {
+				pid, _ = strconv.Atoi(r.Text[len("Process id "):])
+				So(r.Stream, ShouldEqual, StreamSupervisor)
+				So(r.Level, ShouldEqual, LevelInfo)
+			}
+			streams[r.Stream] = r
+		}
+		So(pid, ShouldNotEqual, 0)
+		So(streams[StreamStdout].Text, ShouldEqual,
+			"Sleeping 5 secs (stdout)")
+		So(streams[StreamStdout].Pid, ShouldEqual, pid)
+		So(streams[StreamStdout].String(), ShouldEqual,
+			"[ProcessLogRecords:S1] stdout> Sleeping 5 secs (stdout)")
+		So(streams[StreamStderr].Text, ShouldEqual,
+			"Sleep 5 secs (stderr)")
+		So(streams[StreamStderr].Pid, ShouldEqual, pid)
+
+		// The manager log has the records of every service.
+		recs, _ = m.GetLog(0)
+		found := false
+		for _, r := range recs {
+			if r.Service == "ProcessLogRecords:S2" &&
+				r.Level == LevelError {
+				found = true
+			}
+		}
+		So(found, ShouldBeTrue)
+	})
+}
@@ -47,6 +47,17 @@ checkwd)
 	exit 0
 	;;
 
+checkenv)
+	eval val=\"\${$2}\"
+	if [ "$val" != "$3" ]
+	then
+		error "$2 is \"$val\", expected \"$3\""
+		exit 1
+	fi
+	echo "$2 is $val"
+	exit 0
+	;;
+
 fail)
 	error "Injected failure"
 	exit 2
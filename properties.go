@@ -22,7 +22,7 @@ type PropertyName string
 
 const (
 	PropLogger      PropertyName = "_Logger"      // Where logs get sent
-	PropRestart                  = "_Restart"     // Auto-restart on failure
+	PropRestart                  = "_Restart"     // Auto-restart RestartMode
 	PropRateLimit                = "_RateLimit"   // Max starts per period
 	PropRatePeriod               = "_RatePeriod"  // Period for RateLimit
 	PropName                     = "_Name"        // Service name
@@ -31,4 +31,16 @@ const (
 	PropConflicts                = "_Conflicts"   // Conflicts list
 	PropProvides                 = "_Provides"    // Provides list
 	PropNotify                   = "_Notify"      // Notification callback
+
+	PropRestartDelay      = "_RestartDelay"      // Initial restart backoff
+	PropRestartMaxDelay   = "_RestartMaxDelay"   // Maximum restart backoff
+	PropRestartMultiplier = "_RestartMultiplier" // Backoff growth factor
+	PropRestartJitter     = "_RestartJitter"     // Random backoff fraction
+	PropRestartResetAfter = "_RestartResetAfter" // Stable time to reset
+	PropRestartAttempts   = "_RestartAttempts"   // Max restarts, 0 = no limit
+
+	PropSchedule = "_Schedule" // Schedule for timed runs
+
+	PropLogFile     = "_LogFile"     // Log file, relative to Manager.LogDir
+	PropLogRotation = "_LogRotation" // LogRotation for the log file
 )
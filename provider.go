@@ -14,6 +14,10 @@
 
 package govisor
 
+import (
+	"syscall"
+)
+
 // Provider is what service providers must implement.  Note that except for
 // the Name and Dependencies elements, the service manager promises not to
 // call these methods concurrently.  That is, implementers need not worry
@@ -75,3 +79,43 @@ type Provider interface {
 	// SetProperty sets the value of a property.
 	SetProperty(PropertyName, interface{}) error
 }
+
+// HealthReporter may be implemented by providers that can describe their
+// health in more detail than Check does, for example the state of individual
+// health probes.  When implemented, the result is included in the status of
+// a healthy service.  An empty string means there is nothing to add.
+type HealthReporter interface {
+	Health() string
+}
+
+// Readier may be implemented by providers that are not immediately usable
+// once started, such as daemons that must first open databases or bind
+// sockets.  Services that depend upon such a provider are not started until
+// Ready returns true.  The provider should use the PropNotify callback to
+// report the change, so that dependents are started promptly.
+type Readier interface {
+	Ready() bool
+}
+
+// Completer may be implemented by providers that run to completion, rather
+// than indefinitely.  A completed provider remains running as far as the
+// service is concerned, so that it satisfies dependencies, but its status
+// is reported as completed rather than healthy.  It can be run again with
+// Restart.
+type Completer interface {
+	Completed() bool
+}
+
+// Reloader may be implemented by providers that can reload their
+// configuration without being restarted.  Reload should return once the
+// reload has been requested, or has failed.
+type Reloader interface {
+	Reload() error
+}
+
+// Signaler may be implemented by providers that run processes, so that
+// they can be sent arbitrary signals.  If group is true, the signal goes to
+// the whole process group, rather than just the main process.
+type Signaler interface {
+	Signal(sig syscall.Signal, group bool) error
+}
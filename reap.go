@@ -0,0 +1,62 @@
+// Copyright 2016 The Govisor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package govisor
+
+import (
+	"os/exec"
+	"sync"
+)
+
+// invocationEnv is set in the environment of each process to a value that
+// is unique to that start of it.  Its descendants inherit it, so that those
+// that outlive it can be found, even once they have been orphaned.
+const invocationEnv = "GOVISOR_INVOCATION_ID"
+
+// straggler is a process left behind by a service's process, outside its
+// process group.  The start time tells it apart from any later process
+// that reuses the pid.
+type straggler struct {
+	pid   int
+	start uint64
+}
+
+// children are the processes that we started, and have yet to wait for.
+// The orphan reaper must leave these alone, as exec.Cmd waits for them.
+var children = struct {
+	sync.Mutex
+	pids map[int]bool
+}{pids: make(map[int]bool)}
+
+// startChild starts the command, and records it as one of our children.
+// The lock is held across the start, so that the reaper cannot see the
+// child before it is recorded.
+func startChild(c *exec.Cmd) error {
+	children.Lock()
+	defer children.Unlock()
+	if e := c.Start(); e != nil {
+		return e
+	}
+	children.pids[c.Process.Pid] = true
+	return nil
+}
+
+// waitChild waits for a command started by startChild.
+func waitChild(c *exec.Cmd) error {
+	e := c.Wait()
+	children.Lock()
+	delete(children.pids, c.Process.Pid)
+	children.Unlock()
+	return e
+}
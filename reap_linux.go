@@ -0,0 +1,203 @@
+// Copyright 2016 The Govisor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build linux
+
+package govisor
+
+import (
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// SetSubreaper makes this program the subreaper for the processes that
+// it starts, so that their descendants are reparented to us rather than
+// to init when their parents exit.  Such orphans are reaped as they exit,
+// and any still running when the service's process exits are killed.
+// Without a subreaper, orphans are only found if they are reparented to
+// init.
+// Only processes started by govisor are waited for by their own commands,
+// so this should not be used by programs that start other processes.
+func SetSubreaper() error {
+	if e := unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 1, 0, 0, 0); e != nil {
+		return os.NewSyscallError("prctl", e)
+	}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGCHLD)
+	go func() {
+		for range sigs {
+			reapOrphans()
+		}
+	}()
+	return nil
+}
+
+// procStat is what we need of /proc/<pid>/stat.
+type procStat struct {
+	pid   int
+	ppid  int
+	pgid  int
+	state string
+	start uint64 // Start time, in clock ticks after boot
+}
+
+func readStat(pid int) (procStat, bool) {
+	ps := procStat{pid: pid}
+	b, e := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if e != nil {
+		return ps, false
+	}
+	// The fields after the command name are the state, parent, process
+	// group and so on; the start time is the twentieth.
+	s := string(b)
+	f := strings.Fields(s[strings.LastIndex(s, ")")+1:])
+	if len(f) < 20 {
+		return ps, false
+	}
+	ps.state = f[0]
+	ps.ppid, _ = strconv.Atoi(f[1])
+	ps.pgid, _ = strconv.Atoi(f[2])
+	ps.start, _ = strconv.ParseUint(f[19], 10, 64)
+	return ps, true
+}
+
+// scanProcs returns every process.
+func scanProcs() []procStat {
+	dirs, e := ioutil.ReadDir("/proc")
+	if e != nil {
+		return nil
+	}
+	procs := make([]procStat, 0, len(dirs))
+	for _, d := range dirs {
+		pid, e := strconv.Atoi(d.Name())
+		if e != nil {
+			continue
+		}
+		if ps, ok := readStat(pid); ok {
+			procs = append(procs, ps)
+		}
+	}
+	return procs
+}
+
+// hasEnv returns true if the environment of process pid has kv in it.
+func hasEnv(pid int, kv string) bool {
+	b, e := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/environ")
+	if e != nil {
+		return false
+	}
+	for _, v := range strings.Split(string(b), "\x00") {
+		if v == kv {
+			return true
+		}
+	}
+	return false
+}
+
+// findStragglers returns the live descendants of process pid, and the
+// orphans (now children of ours, or of init) whose environment shows that
+// they came from the invocation of it, together with their descendants.
+// A pid of zero finds just the orphans, as is needed once the process has
+// been waited for, and its pid may have been reused.
+func findStragglers(pid int, invocation string) []straggler {
+	self := os.Getpid()
+	kv := invocationEnv + "=" + invocation
+	procs := scanProcs()
+	kids := make(map[int][]procStat)
+	for _, ps := range procs {
+		kids[ps.ppid] = append(kids[ps.ppid], ps)
+	}
+	var ss []straggler
+	var todo []int
+	if pid != 0 {
+		todo = append(todo, pid)
+	}
+	for _, ps := range procs {
+		if ps.pid != pid && ps.state != "Z" &&
+			(ps.ppid == self || ps.ppid == 1) && hasEnv(ps.pid, kv) {
+			ss = append(ss, straggler{pid: ps.pid, start: ps.start})
+			todo = append(todo, ps.pid)
+		}
+	}
+	seen := make(map[int]bool)
+	for len(todo) != 0 {
+		cur := todo[0]
+		todo = todo[1:]
+		if seen[cur] {
+			continue
+		}
+		seen[cur] = true
+		for _, ps := range kids[cur] {
+			if ps.state != "Z" {
+				ss = append(ss, straggler{pid: ps.pid, start: ps.start})
+			}
+			todo = append(todo, ps.pid)
+		}
+	}
+	return ss
+}
+
+// signalStragglers sends sig to those of the stragglers that are still
+// running, other than those in process group pgid, which are expected to
+// be signaled along with the group.  It returns how many were signaled.
+func signalStragglers(ss []straggler, pgid int, sig syscall.Signal) int {
+	n := 0
+	done := make(map[int]bool)
+	for _, s := range ss {
+		if done[s.pid] {
+			continue
+		}
+		done[s.pid] = true
+		ps, ok := readStat(s.pid)
+		if !ok || ps.start != s.start || ps.state == "Z" ||
+			(pgid != 0 && ps.pgid == pgid) {
+			continue
+		}
+		if syscall.Kill(s.pid, sig) == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// reapOrphans waits for any of our exited children that we did not start.
+// The scan is done without the lock, so as not to hold up the starting
+// and waiting of services.  Children that are found to be ours once the
+// lock is held are left for their own commands to wait for.
+func reapOrphans() {
+	self := os.Getpid()
+	var zombies []int
+	for _, ps := range scanProcs() {
+		if ps.ppid == self && ps.state == "Z" {
+			zombies = append(zombies, ps.pid)
+		}
+	}
+	if len(zombies) == 0 {
+		return
+	}
+	children.Lock()
+	defer children.Unlock()
+	for _, pid := range zombies {
+		if !children.pids[pid] {
+			var ws syscall.WaitStatus
+			syscall.Wait4(pid, &ws, syscall.WNOHANG, nil)
+		}
+	}
+}
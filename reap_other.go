@@ -0,0 +1,36 @@
+// Copyright 2016 The Govisor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !linux
+
+package govisor
+
+import (
+	"syscall"
+)
+
+// SetSubreaper is only implemented for Linux.
+func SetSubreaper() error {
+	return ErrNotSupported
+}
+
+// findStragglers is only implemented for Linux.  Elsewhere, only the
+// process group is signaled.
+func findStragglers(pid int, invocation string) []straggler {
+	return nil
+}
+
+func signalStragglers(ss []straggler, pgid int, sig syscall.Signal) int {
+	return 0
+}
@@ -0,0 +1,262 @@
+// Copyright 2016 The Govisor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package govisor
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// reloadDelay is how long we wait for changes to the services directory
+// to settle, before reloading.  This gives whoever is writing the files
+// a chance to finish.
+const reloadDelay = time.Millisecond * 250
+
+// rewatchDelay is how often we look for the services directory to come
+// back, once it has gone away.
+const rewatchDelay = time.Second
+
+// loadedService records the manifest a service was created from, so that
+// a reload can tell whether it changed.
+type loadedService struct {
+	key      string // The file name, or instanceKey for instances
+	file     string
+	manifest ProcessManifest
+	svc      *Service
+}
+
+// LoadServices adds a service for each manifest found in the directory.
+// The services are not enabled; use RestoreState or Enable for that.  The
+// directory is remembered, so that Reload can rescan it later.  Manifests
+// that cannot be loaded are logged and skipped, and the last such error is
+// returned.
+func (m *Manager) LoadServices(dir string) error {
+	m.reloadMx.Lock()
+	defer m.reloadMx.Unlock()
+
+	m.svcDir = dir
+	return m.reload(false)
+}
+
+// Reload rescans the directory given to LoadServices.  Services for new
+// manifests are added (and enabled as RestoreState would do), services
+// whose manifests were removed are deleted, and services whose manifests
+// changed are replaced, and enabled again if they were enabled.  Services
+// whose manifests are unchanged are not disturbed.  If a manifest cannot be
+// loaded, the existing service (if any) is kept.  The last such error is
+// returned.
+func (m *Manager) Reload() error {
+	m.reloadMx.Lock()
+	defer m.reloadMx.Unlock()
+
+	if m.svcDir == "" {
+		return ErrNoServiceDir
+	}
+	m.logf("[%s] Reloading services from %s", m.Name(), m.svcDir)
+	return m.reload(true)
+}
+
+// reload does the work for LoadServices and Reload.  Call with the reload
+// lock held.
+func (m *Manager) reload(restore bool) error {
+	infos, e := ioutil.ReadDir(m.svcDir)
+	if e != nil {
+		m.errorf("[%s] Failed to scan services: %v", m.Name(), e)
+		return e
+	}
+
+	type entry struct {
+		key  string
+		file string
+		mf   ProcessManifest
+	}
+	var entries []entry
+	var err error
+	wanted := make(map[string]bool)
+	broken := make(map[string]bool) // Files we keep services for
+	templates := make(map[string]*loadedTemplate)
+	for _, fi := range infos {
+		if fi.IsDir() || strings.HasPrefix(fi.Name(), ".") {
+			continue
+		}
+		fname := filepath.Join(m.svcDir, fi.Name())
+		mf, e := ReadManifest(fname)
+		if e == nil {
+			if tmpl := templateName(fname, mf); tmpl != "" {
+				e = checkTemplate(tmpl, mf)
+			} else if len(mf.Instances) != 0 {
+				e = errors.New("Instances given, but not a template")
+			}
+		}
+		if e != nil {
+			m.errorf("[%s] Failed to load manifest %s: %v", m.Name(),
+				fname, e)
+			err = e
+			broken[fname] = true
+			for _, lt := range m.templates {
+				if lt.file == fname {
+					templates[lt.name] = lt
+				}
+			}
+			continue
+		}
+		tmpl := templateName(fname, mf)
+		if tmpl == "" {
+			wanted[fname] = true
+			entries = append(entries, entry{fname, fname, mf})
+			continue
+		}
+		templates[tmpl] = &loadedTemplate{
+			file:     fname,
+			name:     tmpl,
+			manifest: mf,
+		}
+		m.lock()
+		insts := m.instances(tmpl, mf)
+		m.unlock()
+		for _, inst := range insts {
+			key := instanceKey(fname, inst)
+			wanted[key] = true
+			entries = append(entries, entry{key, fname,
+				instanceManifest(tmpl, mf, inst)})
+		}
+	}
+	m.templates = templates
+
+	// Remove services first, so that a manifest that moved to a new file
+	// does not collide with itself.
+	for key, ls := range m.loaded {
+		if !wanted[key] && !broken[ls.file] {
+			m.logf("[%s] Manifest %s removed", m.Name(), key)
+			m.unload(ls)
+			m.lock()
+			delete(m.saved, ls.svc.Name())
+			m.unlock()
+		}
+	}
+
+	for _, ent := range entries {
+		old := m.loaded[ent.key]
+		if old != nil && reflect.DeepEqual(old.manifest, ent.mf) {
+			continue
+		}
+		if _, e := m.load(ent.key, ent.file, ent.mf, old, restore); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// load creates a service from the manifest, replacing the old one (if any)
+// and preserving its enabled and masked state.  New services are restored
+// if restore is true.  Call with the reload lock held.
+func (m *Manager) load(key, file string, mf ProcessManifest, old *loadedService, restore bool) (*Service, error) {
+	enabled, masked := false, false
+	if old != nil {
+		m.logf("[%s] Manifest %s changed", m.Name(), key)
+		enabled, masked = old.svc.Enabled(), old.svc.Masked()
+		m.unload(old)
+	}
+	svc := NewProcessFromManifest(mf)
+	if e := m.AddService(svc); e != nil {
+		return nil, e
+	}
+	m.loaded[key] = &loadedService{
+		key:      key,
+		file:     file,
+		manifest: mf,
+		svc:      svc,
+	}
+	switch {
+	case masked:
+		svc.Mask()
+	case enabled:
+		svc.Enable()
+	case old == nil && restore:
+		m.restoreService(svc)
+	}
+	return svc, nil
+}
+
+func (m *Manager) unload(ls *loadedService) {
+	ls.svc.Disable()
+	m.DeleteService(ls.svc)
+	delete(m.loaded, ls.key)
+}
+
+// StartAutoReload watches the directory given to LoadServices, and reloads
+// automatically whenever files in it are created, changed or removed.
+// Reloads are deferred until changes have settled for a short while.  As
+// with Reload, a manifest that cannot be loaded (perhaps because it is only
+// partly written) does not disturb the existing service, and errors are
+// logged.  If the directory goes away, or is replaced, then it is watched
+// again once it is back.  At present this is only supported on Linux.
+func (m *Manager) StartAutoReload() error {
+	m.reloadMx.Lock()
+	dir := m.svcDir
+	m.reloadMx.Unlock()
+	if dir == "" {
+		return ErrNoServiceDir
+	}
+
+	m.lock()
+	defer m.unlock()
+	if m.watchq != nil {
+		return nil
+	}
+	q := make(chan struct{})
+	if e := m.watchDir(dir, q); e != nil {
+		m.errorf("[%s] Cannot watch %s: %v", m.Name(), dir, e)
+		return e
+	}
+	m.watchq = q
+	m.logf("[%s] Watching %s for changes", m.Name(), dir)
+	return nil
+}
+
+// StopAutoReload stops watching the services directory.
+func (m *Manager) StopAutoReload() {
+	m.lock()
+	if m.watchq != nil {
+		close(m.watchq)
+		m.watchq = nil
+	}
+	m.unlock()
+}
+
+// debounce reloads once the events channel has been quiet for reloadDelay,
+// and exits when the channel is closed, after any reload that is pending.
+func (m *Manager) debounce(events chan struct{}) {
+	var timer <-chan time.Time
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				if timer != nil {
+					m.Reload()
+				}
+				return
+			}
+			timer = time.After(reloadDelay)
+		case <-timer:
+			timer = nil
+			m.Reload()
+		}
+	}
+}
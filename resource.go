@@ -0,0 +1,237 @@
+// Copyright 2016 The Govisor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package govisor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RlimitInfinity is the value of an unlimited resource limit.
+const RlimitInfinity = ^uint64(0)
+
+// Rlimit is a resource limit, as given in a manifest.  It is either a
+// single value, used for both the soft and hard limits, or "soft:hard".
+// Each value is a number, or "unlimited" (or "infinity").  An empty Rlimit
+// leaves the limit inherited from the daemon alone.  In manifests, a plain
+// number may be given instead of a string.
+type Rlimit string
+
+// Values returns the soft and hard limits.
+func (r Rlimit) Values() (uint64, uint64, error) {
+	s := string(r)
+	soft, hard := s, s
+	if i := strings.Index(s, ":"); i >= 0 {
+		soft, hard = s[:i], s[i+1:]
+	}
+	cur, e := parseRlimit(soft)
+	if e != nil {
+		return 0, 0, fmt.Errorf("Bad resource limit %q", s)
+	}
+	max, e := parseRlimit(hard)
+	if e != nil || cur > max {
+		return 0, 0, fmt.Errorf("Bad resource limit %q", s)
+	}
+	return cur, max, nil
+}
+
+func parseRlimit(s string) (uint64, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "unlimited", "infinity":
+		return RlimitInfinity, nil
+	}
+	return strconv.ParseUint(strings.TrimSpace(s), 10, 64)
+}
+
+// UnmarshalJSON implements json.Unmarshaler, accepting either a number
+// or a string.
+func (r *Rlimit) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	dec := json.NewDecoder(strings.NewReader(string(b)))
+	dec.UseNumber()
+	if e := dec.Decode(&v); e != nil {
+		return e
+	}
+	return r.set(v)
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler, accepting the same values
+// as UnmarshalJSON.
+func (r *Rlimit) UnmarshalYAML(n *yaml.Node) error {
+	var v interface{}
+	if e := n.Decode(&v); e != nil {
+		return e
+	}
+	return r.set(v)
+}
+
+// UnmarshalTOML implements toml.Unmarshaler, accepting the same values
+// as UnmarshalJSON.
+func (r *Rlimit) UnmarshalTOML(v interface{}) error {
+	return r.set(v)
+}
+
+func (r *Rlimit) set(v interface{}) error {
+	var s string
+	switch v := v.(type) {
+	case string:
+		s = v
+	case json.Number:
+		s = v.String()
+	case int:
+		s = strconv.Itoa(v)
+	case int64:
+		s = strconv.FormatInt(v, 10)
+	case uint64:
+		s = strconv.FormatUint(v, 10)
+	default:
+		return ErrBadPropType
+	}
+	if s != "" {
+		if _, _, e := Rlimit(s).Values(); e != nil {
+			return e
+		}
+	}
+	*r = Rlimit(s)
+	return nil
+}
+
+// setRlimit sets a resource limit property, which may be an Rlimit or a
+// string.
+func setRlimit(r *Rlimit, v interface{}) error {
+	var l Rlimit
+	switch v := v.(type) {
+	case Rlimit:
+		l = v
+	case string:
+		l = Rlimit(v)
+	default:
+		return ErrBadPropType
+	}
+	if l != "" {
+		if _, _, e := l.Values(); e != nil {
+			return ErrBadPropValue
+		}
+	}
+	*r = l
+	return nil
+}
+
+// parseUmask parses an octal file creation mask, such as "022".
+func parseUmask(s string) (int, error) {
+	v, e := strconv.ParseUint(s, 8, 32)
+	if e != nil || v > 0777 {
+		return 0, fmt.Errorf("Bad umask %q", s)
+	}
+	return int(v), nil
+}
+
+// validateResources checks the resource controls of a manifest.
+func validateResources(m ProcessManifest) error {
+	for _, r := range []Rlimit{m.LimitNoFile, m.LimitNProc, m.LimitCore,
+		m.LimitAS} {
+		if r == "" {
+			continue
+		}
+		if _, _, e := r.Values(); e != nil {
+			return e
+		}
+	}
+	if m.Nice < -20 || m.Nice > 19 {
+		return fmt.Errorf("Bad nice level %d", m.Nice)
+	}
+	for _, cpu := range m.CPUAffinity {
+		if cpu < 0 {
+			return fmt.Errorf("Bad CPU %d", cpu)
+		}
+	}
+	if m.Umask != "" {
+		if _, e := parseUmask(m.Umask); e != nil {
+			return e
+		}
+	}
+	if m.OOMScoreAdj < -1000 || m.OOMScoreAdj > 1000 {
+		return fmt.Errorf("Bad OOM score adjustment %d", m.OOMScoreAdj)
+	}
+	return nil
+}
+
+// launcherReady is set by RunLauncher, and tells us that our executable
+// can act as the launcher.
+var launcherReady bool
+
+// RunLauncher must be called first thing in main by a program that runs
+// services with resource controls, such as limits, a nice level or a
+// umask.  These are applied by running the program again as a launcher,
+// which executes the command once they are in place.  If this process is
+// such a launcher, then RunLauncher does that, and never returns.
+// Otherwise it returns at once.  Without it, services with resource
+// controls fail to start, with ErrNoLauncher.
+func RunLauncher() {
+	runLauncher()
+	launcherReady = true
+}
+
+// launcher runs a command by way of our own executable, which sets the
+// resource controls and umask of the process, and drops privileges, before
+// executing the command.  Go offers no hook between fork and exec, and
+// setting these from outside once the command is running is too late, so
+// this is the only way to have the command start with them.  The launcher
+// reports failure on a pipe that is closed when the command is executed.
+type launcher struct {
+	r *os.File
+	w *os.File
+}
+
+// wait returns the error reported by the launcher, if any.  Call it once
+// the command has started.  A nil launcher reports no error.
+func (l *launcher) wait() error {
+	if l == nil {
+		return nil
+	}
+	l.w.Close()
+	b, e := ioutil.ReadAll(l.r)
+	l.r.Close()
+	if e != nil {
+		return e
+	}
+	if len(b) != 0 {
+		return errors.New(string(b))
+	}
+	return nil
+}
+
+// close releases the launcher, if the command could not be started.
+func (l *launcher) close() {
+	if l != nil {
+		l.r.Close()
+		l.w.Close()
+	}
+}
+
+// hasResources returns true if any resource controls, including the
+// umask, are set.
+func (p *Process) hasResources() bool {
+	return p.limitNoFile != "" || p.limitNProc != "" ||
+		p.limitCore != "" || p.limitAS != "" || p.nice != 0 ||
+		len(p.affinity) != 0 || p.oomScoreAdj != 0 || p.umask != ""
+}
@@ -0,0 +1,225 @@
+// Copyright 2016 The Govisor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build linux
+
+package govisor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// launchEnv is the environment variable that tells our executable to act
+// as the launcher, and holds the launchSpec.
+const launchEnv = "GOVISOR_LAUNCH"
+
+// launchSpec is what the launcher does before executing the command.
+type launchSpec struct {
+	Path        string        `json:"path"`
+	Limits      []launchLimit `json:"limits,omitempty"`
+	Nice        int           `json:"nice,omitempty"`
+	Affinity    []int         `json:"affinity,omitempty"`
+	OOMScoreAdj int           `json:"oomScoreAdj,omitempty"`
+	Umask       int           `json:"umask"` // -1 to leave it alone
+	Cred        *launchCred   `json:"cred,omitempty"`
+	ErrFd       int           `json:"errFd"`
+}
+
+type launchLimit struct {
+	Name     string `json:"name"`
+	Resource int    `json:"resource"`
+	Cur      uint64 `json:"cur"`
+	Max      uint64 `json:"max"`
+}
+
+type launchCred struct {
+	Uid    uint32   `json:"uid"`
+	Gid    uint32   `json:"gid"`
+	Groups []uint32 `json:"groups"`
+}
+
+// runLauncher runs the launcher if our executable was started as one.
+func runLauncher() {
+	if spec := os.Getenv(launchEnv); spec != "" {
+		launch(spec)
+	}
+}
+
+// newLauncher arranges for the command to be run by the launcher, if the
+// process has any resource controls.  Otherwise it returns nil.  The
+// launcher is our own executable, so it must call RunLauncher before it
+// does anything else.
+func (p *Process) newLauncher(c *exec.Cmd) (*launcher, error) {
+	if !p.hasResources() {
+		return nil, nil
+	}
+	if !launcherReady {
+		return nil, ErrNoLauncher
+	}
+	spec := launchSpec{
+		Path:        c.Path,
+		Nice:        p.nice,
+		Affinity:    p.affinity,
+		OOMScoreAdj: p.oomScoreAdj,
+		Umask:       -1,
+		ErrFd:       3 + len(c.ExtraFiles),
+	}
+	limits := []struct {
+		name  string
+		res   int
+		limit Rlimit
+	}{
+		{"RLIMIT_NOFILE", unix.RLIMIT_NOFILE, p.limitNoFile},
+		{"RLIMIT_NPROC", unix.RLIMIT_NPROC, p.limitNProc},
+		{"RLIMIT_CORE", unix.RLIMIT_CORE, p.limitCore},
+		{"RLIMIT_AS", unix.RLIMIT_AS, p.limitAS},
+	}
+	for _, l := range limits {
+		if l.limit == "" {
+			continue
+		}
+		cur, max, e := l.limit.Values()
+		if e != nil {
+			return nil, e
+		}
+		spec.Limits = append(spec.Limits,
+			launchLimit{Name: l.name, Resource: l.res, Cur: cur, Max: max})
+	}
+	if p.umask != "" {
+		mask, e := parseUmask(p.umask)
+		if e != nil {
+			return nil, e
+		}
+		spec.Umask = mask
+	}
+	// Raising limits, lowering the nice level and the OOM score all need
+	// privileges that the command may not have, so the launcher runs
+	// with ours, and drops them itself.
+	if attr := c.SysProcAttr; attr != nil && attr.Credential != nil {
+		spec.Cred = &launchCred{
+			Uid:    attr.Credential.Uid,
+			Gid:    attr.Credential.Gid,
+			Groups: attr.Credential.Groups,
+		}
+		nattr := *attr
+		nattr.Credential = nil
+		c.SysProcAttr = &nattr
+	}
+	b, e := json.Marshal(&spec)
+	if e != nil {
+		return nil, e
+	}
+	r, w, e := os.Pipe()
+	if e != nil {
+		return nil, e
+	}
+	env := c.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	c.Env = append(append([]string{}, env...), launchEnv+"="+string(b))
+	c.ExtraFiles = append(append([]*os.File{}, c.ExtraFiles...), w)
+	// This is the executable we are running, even if it has since been
+	// replaced.
+	c.Path = "/proc/self/exe"
+	return &launcher{r: r, w: w}, nil
+}
+
+// launch is the launcher.  It applies the spec to itself, and executes the
+// command in its place, with the arguments it was given.  Nice levels and
+// CPU affinity are per thread on Linux, so this stays on the thread that
+// executes the command.  It never returns.
+func launch(s string) {
+	runtime.LockOSThread()
+	var spec launchSpec
+	if e := json.Unmarshal([]byte(s), &spec); e != nil {
+		fmt.Fprintf(os.Stderr, "govisor launcher: %v\n", e)
+		os.Exit(127)
+	}
+	errf := os.NewFile(uintptr(spec.ErrFd), "launch")
+	syscall.CloseOnExec(spec.ErrFd)
+
+	e := spec.apply()
+	if e == nil {
+		var env []string
+		for _, kv := range os.Environ() {
+			if !strings.HasPrefix(kv, launchEnv+"=") {
+				env = append(env, kv)
+			}
+		}
+		e = syscall.Exec(spec.Path, os.Args, env)
+		e = fmt.Errorf("exec %s: %v", spec.Path, e)
+	}
+	errf.WriteString(e.Error())
+	os.Exit(127)
+}
+
+func (spec *launchSpec) apply() error {
+	for _, l := range spec.Limits {
+		rl := &syscall.Rlimit{Cur: l.Cur, Max: l.Max}
+		if e := syscall.Setrlimit(l.Resource, rl); e != nil {
+			return fmt.Errorf("%s: %v", l.Name, e)
+		}
+	}
+	if spec.Nice != 0 {
+		if e := unix.Setpriority(unix.PRIO_PROCESS, 0, spec.Nice); e != nil {
+			return fmt.Errorf("nice: %v", e)
+		}
+	}
+	if len(spec.Affinity) != 0 {
+		var set unix.CPUSet
+		for _, cpu := range spec.Affinity {
+			set.Set(cpu)
+		}
+		if e := unix.SchedSetaffinity(0, &set); e != nil {
+			return fmt.Errorf("CPU affinity: %v", e)
+		}
+	}
+	if spec.OOMScoreAdj != 0 {
+		b := []byte(strconv.Itoa(spec.OOMScoreAdj))
+		e := ioutil.WriteFile("/proc/self/oom_score_adj", b, 0644)
+		if e != nil {
+			return fmt.Errorf("oom_score_adj: %v", e)
+		}
+	}
+	if spec.Umask >= 0 {
+		syscall.Umask(spec.Umask)
+	}
+	if c := spec.Cred; c != nil {
+		groups := make([]int, 0, len(c.Groups))
+		for _, g := range c.Groups {
+			groups = append(groups, int(g))
+		}
+		if e := syscall.Setgroups(groups); e != nil {
+			return fmt.Errorf("setgroups: %v", e)
+		}
+		if e := syscall.Setgid(int(c.Gid)); e != nil {
+			return fmt.Errorf("setgid: %v", e)
+		}
+		if e := syscall.Setuid(int(c.Uid)); e != nil {
+			return fmt.Errorf("setuid: %v", e)
+		}
+	}
+	return nil
+}
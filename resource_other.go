@@ -0,0 +1,34 @@
+// Copyright 2016 The Govisor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !linux
+
+package govisor
+
+import (
+	"os/exec"
+)
+
+// runLauncher does nothing, as there is no launcher.
+func runLauncher() {
+}
+
+// newLauncher is only implemented for Linux, so resource controls cannot
+// be used elsewhere.
+func (p *Process) newLauncher(c *exec.Cmd) (*launcher, error) {
+	if p.hasResources() {
+		return nil, ErrNotSupported
+	}
+	return nil, nil
+}
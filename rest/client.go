@@ -27,12 +27,21 @@ import (
 	"golang.org/x/net/context"
 )
 
+// LogInfo is the log of a service, or of the manager, as returned by
+// GetLog and WatchLog.  Lost is true if WatchLog found that records after
+// those of the previous LogInfo were no longer kept, so that they were
+// missed.  Records then has only the records that are kept.
 type LogInfo struct {
 	name    string
 	etag    string
+	Lost    bool
 	Records []LogRecord
 }
 
+// MaxLogRecords is the most records kept in a LogInfo.  WatchLog discards
+// the oldest beyond this.
+const MaxLogRecords = 1000
+
 type Client struct {
 	user      string // HTTP Basic-Auth
 	pass      string
@@ -189,10 +198,20 @@ type chanResp struct {
 }
 
 func (c *Client) poll(ctx context.Context, url string, etag string, wait int, v interface{}) (string, error) {
+	h, e := c.get(ctx, url, etag, wait, v)
+	if h == nil {
+		return "", e
+	}
+	return h.Get("Etag"), nil
+}
+
+// get is like poll, but returns the headers of the response, or nil if
+// the value did not change.
+func (c *Client) get(ctx context.Context, url string, etag string, wait int, v interface{}) (http.Header, error) {
 
 	req, e := http.NewRequest("GET", url, nil)
 	if e != nil {
-		return "", e
+		return nil, e
 	}
 	if c.auth {
 		req.SetBasicAuth(c.user, c.pass)
@@ -225,37 +244,37 @@ func (c *Client) poll(ctx context.Context, url string, etag string, wait int, v
 	switch e {
 	case nil:
 	case context.DeadlineExceeded:
-		return "", &Error{
+		return nil, &Error{
 			Code:    http.StatusRequestTimeout,
 			Message: "Request timed out",
 		}
 	default:
-		return "", e
+		return nil, e
 	}
 
 	defer res.Body.Close()
 	if res.StatusCode == http.StatusNotModified {
-		return "", nil
+		return nil, nil
 	}
 	if res.StatusCode != http.StatusOK {
 		err := &Error{Code: res.StatusCode, Message: res.Status}
 
 		if ebody, e := ioutil.ReadAll(res.Body); e == nil {
 			if e := json.Unmarshal(ebody, err); e == nil {
-				return "", err
+				return nil, err
 			}
 		}
 
-		return "", &Error{Code: res.StatusCode, Message: res.Status}
+		return nil, &Error{Code: res.StatusCode, Message: res.Status}
 	}
 	body, e := ioutil.ReadAll(res.Body)
 	if e != nil {
-		return "", e
+		return nil, e
 	}
 	if e := json.Unmarshal(body, v); e != nil {
-		return "", e
+		return nil, e
 	}
-	return res.Header.Get("Etag"), nil
+	return res.Header, nil
 }
 
 func (c *Client) post(url string) error {
@@ -306,49 +325,92 @@ func (c *Client) RestartService(name string) error {
 	return c.postService(name, "restart")
 }
 
-func (c *Client) pollLog(ctx context.Context, name string, secs int, last *LogInfo) (*LogInfo, error) {
+// ReloadService asks a running service to reload its configuration,
+// without restarting it.
+func (c *Client) ReloadService(name string) error {
+	return c.postService(name, "reload")
+}
 
-	v := &LogInfo{}
+// SignalService sends the named signal, such as "SIGUSR1", to a running
+// service.  If group is true, it is sent to the whole process group.
+func (c *Client) SignalService(name string, sig string, group bool) error {
+	v := url.Values{}
+	v.Set("signal", sig)
+	if group {
+		v.Set("target", "group")
+	}
+	return c.postService(name, "signal?"+v.Encode())
+}
 
-	c.lock.Lock()
-	cached, ok := c.logs[name]
-	c.lock.Unlock()
+func (c *Client) MaskService(name string) error {
+	return c.postService(name, "mask")
+}
+
+func (c *Client) UnmaskService(name string) error {
+	return c.postService(name, "unmask")
+}
+
+// Reload asks the server to rescan its service manifests.
+func (c *Client) Reload() error {
+	return c.post(c.base + "/reload")
+}
 
+// Instantiate asks the server to create an instance of a template.  The
+// name has the form <template>@<instance>.
+func (c *Client) Instantiate(name string) error {
+	return c.post(c.base + "/instantiate/" + url.QueryEscape(name))
+}
+
+// Uninstantiate asks the server to remove an instance created by
+// Instantiate.
+func (c *Client) Uninstantiate(name string) error {
+	return c.post(c.base + "/uninstantiate/" + url.QueryEscape(name))
+}
+
+func (c *Client) logURL(name string) string {
+	if name == "" {
+		return c.base + "/log"
+	}
+	return c.url(name) + "/log"
+}
+
+// pollLog gets the log.  If last is not nil, then only the records after
+// those in last are fetched, and added to them.
+func (c *Client) pollLog(ctx context.Context, name string, secs int, last *LogInfo) (*LogInfo, error) {
+
+	v := &LogInfo{name: name}
+	u := c.logURL(name)
 	otag := ""
 
 	if last == nil {
 		secs = 0
-	} else if ok && last.etag != cached.etag {
-		// TODO: We should modify this to validate the cached etag
-		// VERIFY THIS
-		//
-		secs = 0
-		otag = cached.etag
-		//
-		// If we asked for a check against a value, and the cached
-		// value is not the same, then we can return the cached value.
-		//return cached, nil
 	} else {
-		// Either we didn't have a value cached, or they are the same.
 		otag = last.etag
+		if n := len(last.Records); n != 0 {
+			u += "?since=" + url.QueryEscape(last.Records[n-1].Id)
+		}
 	}
 
-	url := c.url(name) + "/log"
-	if name == "" {
-		url = c.base + "/log"
-	}
-
-	etag, e := c.poll(ctx, url, otag, secs, &v.Records)
+	h, e := c.get(ctx, u, otag, secs, &v.Records)
 	if e != nil {
 		c.lock.Lock()
 		delete(c.logs, name)
 		c.lock.Unlock()
 		return nil, e
 	}
-	if etag == "" {
-		return cached, nil
+	if h == nil {
+		return last, nil
+	}
+	v.etag = h.Get("Etag")
+	v.Lost = h.Get(LogLostHeader) == "true"
+	if last != nil && !v.Lost {
+		recs := make([]LogRecord, 0, len(last.Records)+len(v.Records))
+		recs = append(recs, last.Records...)
+		v.Records = append(recs, v.Records...)
+	}
+	if n := len(v.Records) - MaxLogRecords; n > 0 {
+		v.Records = v.Records[n:]
 	}
-	v.etag = etag
 	c.lock.Lock()
 	c.logs[name] = v
 	c.lock.Unlock()
@@ -356,6 +418,96 @@ func (c *Client) pollLog(ctx context.Context, name string, secs int, last *LogIn
 	return v, nil
 }
 
+// GetLogSince returns up to limit records of the log, or all of them if
+// limit is zero, after the record with ID since.  An empty since returns
+// the records that the server holds in memory.  The server returns at
+// most MaxLogRecords at once, so reading older records may need to be
+// continued from the last of those returned.  The bool is true if records
+// after since are no longer kept, and so were missed.
+func (c *Client) GetLogSince(name string, since string, limit int) ([]LogRecord, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	q := url.Values{}
+	if since != "" {
+		q.Set("since", since)
+	}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+	u := c.logURL(name)
+	if len(q) != 0 {
+		u += "?" + q.Encode()
+	}
+	var recs []LogRecord
+	h, e := c.get(ctx, u, "", 0, &recs)
+	if e != nil {
+		return nil, false, e
+	}
+	return recs, h.Get(LogLostHeader) == "true", nil
+}
+
+// LogQuery selects the records returned by SearchLog.  Zero fields select
+// every record.
+type LogQuery struct {
+	From    time.Time // Only records from this time onwards
+	To      time.Time // Only records up to this time
+	Streams []string  // Only records on one of these streams
+	Level   string    // Least severe level of record to select
+	Match   string    // Only records whose text contains this
+	Regexp  string    // Only records whose text matches this
+	Limit   int       // At most this many of the oldest records
+	Tail    int       // At most this many of the newest records
+}
+
+// values returns the query parameters for the query.
+func (q LogQuery) values() url.Values {
+	v := url.Values{}
+	if !q.From.IsZero() {
+		v.Set("from", q.From.Format(time.RFC3339Nano))
+	}
+	if !q.To.IsZero() {
+		v.Set("to", q.To.Format(time.RFC3339Nano))
+	}
+	if len(q.Streams) != 0 {
+		v.Set("stream", strings.Join(q.Streams, ","))
+	}
+	if q.Level != "" {
+		v.Set("level", q.Level)
+	}
+	if q.Match != "" {
+		v.Set("match", q.Match)
+	}
+	if q.Regexp != "" {
+		v.Set("regexp", q.Regexp)
+	}
+	if q.Limit > 0 {
+		v.Set("limit", strconv.Itoa(q.Limit))
+	}
+	if q.Tail > 0 {
+		v.Set("tail", strconv.Itoa(q.Tail))
+	}
+	return v
+}
+
+// SearchLog returns the records of the log that the query selects.  If
+// the server keeps a journal, then the whole of it is searched, otherwise
+// just the records that it holds in memory.
+func (c *Client) SearchLog(name string, q LogQuery) ([]LogRecord, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	u := c.logURL(name)
+	if v := q.values(); len(v) != 0 {
+		u += "?" + v.Encode()
+	}
+	var recs []LogRecord
+	if _, e := c.get(ctx, u, "", 0, &recs); e != nil {
+		return nil, e
+	}
+	return recs, nil
+}
+
 func (c *Client) WatchLog(ctx context.Context, name string, last *LogInfo) (*LogInfo, error) {
 
 	// Let the poll wait for up to 300 secs (5 minutes).
@@ -32,6 +32,11 @@ const (
 	// to make sure that the client is alive and well.
 	PollEtagHeader = "X-Govisor-Poll-Etag"
 	PollTimeHeader = "X-Govisor-Poll-Time"
+
+	// LogLostHeader is set to "true" in a response to a GET of a log
+	// with a since parameter, if records after since are no longer
+	// kept.  The client has fallen behind, and missed them.
+	LogLostHeader = "X-Govisor-Log-Lost"
 )
 
 var ok struct{}
@@ -48,21 +53,91 @@ type ServiceInfo struct {
 	Name        string    `json:"name"`
 	Description string    `json:"description"`
 	Enabled     bool      `json:"enabled"`
+	Masked      bool      `json:"masked"`
 	Running     bool      `json:"running"`
 	Failed      bool      `json:"failed"`
+	Completed   bool      `json:"completed"`
 	Provides    []string  `json:"provides"`
 	Depends     []string  `json:"depends"`
 	Conflicts   []string  `json:"conflicts"`
 	Status      string    `json:"status"`
 	TimeStamp   time.Time `json:"tstamp"`
+	NextRestart time.Time `json:"nextRestart"`
+	Schedule    string    `json:"schedule"`
+	LastRun     time.Time `json:"lastRun"`
+	NextRun     time.Time `json:"nextRun"`
+	LastResult  string    `json:"lastResult"`
 	Serial      string    `json:"serial"`
 	etag        string
 }
 
+// Log streams.  Output from a process is recorded as coming from its
+// standard output or standard error, and messages from govisor itself
+// as coming from the supervisor.
+const (
+	StreamStdout     = "stdout"
+	StreamStderr     = "stderr"
+	StreamSupervisor = "supervisor"
+)
+
+// Log levels, for messages from the supervisor.
+const (
+	LevelInfo    = "info"
+	LevelWarning = "warning"
+	LevelError   = "error"
+)
+
+// Log levels, in increasing order of severity.
+var logLevels = map[string]int{LevelInfo: 1, LevelWarning: 2, LevelError: 3}
+
+// LevelAtLeast returns true if level is at least as severe as min.  An
+// empty or unknown level is less severe than any other, and any level is
+// at least an empty min.  Both the server and its clients select log
+// records by level with this.
+func LevelAtLeast(level, min string) bool {
+	return logLevels[level] >= logLevels[min]
+}
+
+// MatchStream returns true if stream is one of streams, or if streams is
+// empty.
+func MatchStream(stream string, streams []string) bool {
+	if len(streams) == 0 {
+		return true
+	}
+	for _, s := range streams {
+		if stream == s {
+			return true
+		}
+	}
+	return false
+}
+
+// LogRecord is a single line of a log.  Stream is "stdout" or "stderr" for
+// output from a process, and "supervisor" for messages from govisor, which
+// also have a Level of "info", "warning" or "error".  Service is empty for
+// messages from the manager.
 type LogRecord struct {
-	Id   string    `json:"id"`
-	Time time.Time `json:"time"`
-	Text string    `json:"text"`
+	Id      string    `json:"id"`
+	Time    time.Time `json:"time"`
+	Text    string    `json:"text"`
+	Service string    `json:"service,omitempty"`
+	Stream  string    `json:"stream,omitempty"`
+	Level   string    `json:"level,omitempty"`
+	Pid     int       `json:"pid,omitempty"`
+}
+
+// String returns the record as a line of text, prefixed by the service
+// name and, for process output, the stream.
+func (r LogRecord) String() string {
+	s := r.Text
+	switch r.Stream {
+	case StreamStdout, StreamStderr:
+		s = r.Stream + "> " + s
+	}
+	if r.Service != "" {
+		s = "[" + r.Service + "] " + s
+	}
+	return s
 }
 
 type Error struct {
@@ -0,0 +1,104 @@
+// Copyright 2016 The Govisor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package govisor
+
+import (
+	"encoding/json"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RestartMode determines which failures cause a service to be restarted
+// automatically.  These are modeled on the systemd modes of the same names.
+type RestartMode string
+
+const (
+	RestartNever      RestartMode = "never"       // Never restart
+	RestartAlways     RestartMode = "always"      // Even after a clean exit
+	RestartOnFailure  RestartMode = "on-failure"  // Any failure
+	RestartOnAbnormal RestartMode = "on-abnormal" // Signals and health checks
+)
+
+// ParseRestartMode returns the RestartMode for the given name.  The empty
+// string is the same as RestartNever.
+func ParseRestartMode(name string) (RestartMode, error) {
+	switch mode := RestartMode(name); mode {
+	case "":
+		return RestartNever, nil
+	case RestartNever, RestartAlways, RestartOnFailure, RestartOnAbnormal:
+		return mode, nil
+	}
+	return RestartNever, ErrBadPropValue
+}
+
+// UnmarshalJSON implements json.Unmarshaler.  For compatibility with older
+// manifests, a boolean true is the same as "on-failure", and false is the
+// same as "never".
+func (r *RestartMode) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	if e := json.Unmarshal(b, &v); e != nil {
+		return e
+	}
+	return r.set(v)
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler, accepting the same values
+// as UnmarshalJSON.
+func (r *RestartMode) UnmarshalYAML(n *yaml.Node) error {
+	var v interface{}
+	if e := n.Decode(&v); e != nil {
+		return e
+	}
+	return r.set(v)
+}
+
+// UnmarshalTOML implements toml.Unmarshaler, accepting the same values
+// as UnmarshalJSON.
+func (r *RestartMode) UnmarshalTOML(v interface{}) error {
+	return r.set(v)
+}
+
+func (r *RestartMode) set(v interface{}) error {
+	switch v := v.(type) {
+	case bool:
+		if v {
+			*r = RestartOnFailure
+		} else {
+			*r = RestartNever
+		}
+		return nil
+	case string:
+		mode, e := ParseRestartMode(v)
+		if e != nil {
+			return e
+		}
+		*r = mode
+		return nil
+	}
+	return ErrBadPropType
+}
+
+// wants returns true if a failure with the given error should be restarted.
+func (r RestartMode) wants(e error) bool {
+	switch r {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return faultKind(e) != FaultExit
+	case RestartOnAbnormal:
+		return faultKind(e) == FaultAbnormal
+	}
+	return false
+}
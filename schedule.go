@@ -0,0 +1,233 @@
+// Copyright 2016 The Govisor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package govisor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule determines when a scheduled service runs.  Next returns the
+// first time strictly after t at which the service should be started.
+type Schedule interface {
+	Next(t time.Time) time.Time
+	String() string
+}
+
+// IntervalSchedule runs a service at a fixed interval.  The first run is
+// one interval after the service is enabled.
+type IntervalSchedule time.Duration
+
+func (i IntervalSchedule) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(i))
+}
+
+func (i IntervalSchedule) String() string {
+	return "@every " + time.Duration(i).String()
+}
+
+// cronSchedule is a classic five field cron schedule.  Each field is
+// a bit mask of the permitted values.
+type cronSchedule struct {
+	spec   string
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+	anyDom bool // day of month was "*"
+	anyDow bool // day of week was "*"
+}
+
+var cronAliases = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var cronMonths = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var cronDays = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+// ParseSchedule parses a schedule specification.  This is either a
+// standard five field cron expression (minute, hour, day of month, month,
+// day of week), one of the aliases @hourly, @daily, @weekly, @monthly and
+// @yearly, or "@every <duration>" for an IntervalSchedule.  Cron schedules
+// are evaluated in local time.
+func ParseSchedule(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "@every ") {
+		d, e := time.ParseDuration(strings.TrimSpace(spec[len("@every "):]))
+		if e != nil {
+			return nil, fmt.Errorf("Bad schedule %q: %v", spec, e)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("Bad schedule %q: interval must be positive", spec)
+		}
+		return IntervalSchedule(d), nil
+	}
+	expr := spec
+	if alias, ok := cronAliases[spec]; ok {
+		expr = alias
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("Bad schedule %q: expected 5 fields", spec)
+	}
+	cs := &cronSchedule{spec: spec}
+	var e error
+	if cs.minute, e = cronField(fields[0], 0, 59, nil); e != nil {
+		return nil, fmt.Errorf("Bad schedule %q: minute: %v", spec, e)
+	}
+	if cs.hour, e = cronField(fields[1], 0, 23, nil); e != nil {
+		return nil, fmt.Errorf("Bad schedule %q: hour: %v", spec, e)
+	}
+	if cs.dom, e = cronField(fields[2], 1, 31, nil); e != nil {
+		return nil, fmt.Errorf("Bad schedule %q: day of month: %v", spec, e)
+	}
+	if cs.month, e = cronField(fields[3], 1, 12, cronMonths); e != nil {
+		return nil, fmt.Errorf("Bad schedule %q: month: %v", spec, e)
+	}
+	if cs.dow, e = cronField(fields[4], 0, 7, cronDays); e != nil {
+		return nil, fmt.Errorf("Bad schedule %q: day of week: %v", spec, e)
+	}
+	// Sunday may be either 0 or 7.
+	if cs.dow&(1<<7) != 0 {
+		cs.dow |= 1
+	}
+	cs.anyDom = fields[2] == "*"
+	cs.anyDow = fields[4] == "*"
+	return cs, nil
+}
+
+func cronValue(s string, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	return strconv.Atoi(s)
+}
+
+// cronField parses a single comma separated cron field, where each
+// element is "*", a value, or a range, optionally followed by a "/step".
+func cronField(field string, min, max int, names map[string]int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, e := strconv.Atoi(part[i+1:])
+			if e != nil || n <= 0 {
+				return 0, fmt.Errorf("bad step in %q", part)
+			}
+			step = n
+			part = part[:i]
+		}
+		lo, hi := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			i := strings.Index(part, "-")
+			var e error
+			if lo, e = cronValue(part[:i], names); e != nil {
+				return 0, fmt.Errorf("bad value in %q", part)
+			}
+			if hi, e = cronValue(part[i+1:], names); e != nil {
+				return 0, fmt.Errorf("bad value in %q", part)
+			}
+		default:
+			v, e := cronValue(part, names)
+			if e != nil {
+				return 0, fmt.Errorf("bad value %q", part)
+			}
+			lo = v
+			if step == 1 {
+				hi = v
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (cs *cronSchedule) dayMatches(t time.Time) bool {
+	dom := cs.dom&(1<<uint(t.Day())) != 0
+	dow := cs.dow&(1<<uint(t.Weekday())) != 0
+	// As with cron, if both fields are restricted, either may match.
+	switch {
+	case cs.anyDom && cs.anyDow:
+		return true
+	case cs.anyDom:
+		return dow
+	case cs.anyDow:
+		return dom
+	}
+	return dom || dow
+}
+
+func (cs *cronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+
+	// Impossible schedules (such as February 30) would otherwise loop
+	// forever, so give up after five years.
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if cs.month&(1<<uint(t.Month())) == 0 {
+			t = later(t, time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location()))
+			continue
+		}
+		if !cs.dayMatches(t) {
+			t = later(t, time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location()))
+			continue
+		}
+		if cs.hour&(1<<uint(t.Hour())) == 0 {
+			t = later(t, time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location()))
+			continue
+		}
+		if cs.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// later returns n, unless a daylight saving transition means that it is not
+// after t, in which case we just move forward a minute.
+func later(t, n time.Time) time.Time {
+	if n.After(t) {
+		return n
+	}
+	return t.Add(time.Minute)
+}
+
+func (cs *cronSchedule) String() string {
+	return cs.spec
+}
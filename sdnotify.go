@@ -0,0 +1,161 @@
+// Copyright 2016 The Govisor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package govisor
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// notifySocket is a datagram socket on which we receive sd_notify(3) style
+// state updates from a process.  The path is passed to the process in the
+// NOTIFY_SOCKET environment variable, so that existing daemons which
+// support systemd readiness notification work unmodified.
+type notifySocket struct {
+	dir  string
+	conn *net.UnixConn
+}
+
+// newNotifySocket creates a notify socket in a new private directory.  If
+// the process runs as another user, then the directory and socket are
+// given to that user, as otherwise it could not send to the socket.
+func newNotifySocket(cred *credential) (*notifySocket, error) {
+	dir, e := ioutil.TempDir("", "govisor")
+	if e != nil {
+		return nil, e
+	}
+	addr := &net.UnixAddr{Name: filepath.Join(dir, "notify"), Net: "unixgram"}
+	conn, e := net.ListenUnixgram("unixgram", addr)
+	if e != nil {
+		os.RemoveAll(dir)
+		return nil, e
+	}
+	ns := &notifySocket{dir: dir, conn: conn}
+	if cred != nil {
+		for _, name := range []string{dir, ns.path()} {
+			e := os.Chown(name, int(cred.uid), int(cred.gid))
+			if e != nil {
+				ns.close()
+				return nil, e
+			}
+		}
+	}
+	return ns, nil
+}
+
+func (ns *notifySocket) path() string {
+	return filepath.Join(ns.dir, "notify")
+}
+
+func (ns *notifySocket) close() {
+	ns.conn.Close()
+	os.RemoveAll(ns.dir)
+}
+
+// listenNotify receives messages on the notify socket until it is closed.
+// We only act on READY=1, but STATUS= messages are logged as they are
+// likely to be helpful.  Everything else is ignored.
+func (p *Process) listenNotify(ns *notifySocket, proc *os.Process) {
+	buf := make([]byte, 4096)
+	for {
+		n, _, e := ns.conn.ReadFromUnix(buf)
+		if e != nil {
+			return
+		}
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			switch {
+			case line == "READY=1":
+				p.setReady(proc)
+			case strings.HasPrefix(line, "STATUS="):
+				p.logger.Printf("Status: %s", line[len("STATUS="):])
+			}
+		}
+	}
+}
+
+func (p *Process) setReady(proc *os.Process) {
+	p.lock.Lock()
+	if p.process != proc || p.ready {
+		p.lock.Unlock()
+		return
+	}
+	p.ready = true
+	p.logger.Printf("Process ready")
+	notify := p.notify
+	p.lock.Unlock()
+
+	if notify != nil {
+		notify()
+	}
+}
+
+// readyTimedOut faults the process proc if it has not become ready.
+func (p *Process) readyTimedOut(proc *os.Process) {
+	p.lock.Lock()
+	if p.process != proc || p.ready || p.stopped || p.failed {
+		p.lock.Unlock()
+		return
+	}
+	p.failed = true
+	p.reason = &Fault{
+		Kind: FaultAbnormal,
+		Err:  errors.New("Timed out waiting for readiness"),
+	}
+	p.errorf("Failed: %v", p.reason)
+	notify := p.notify
+	p.lock.Unlock()
+
+	if notify != nil {
+		notify()
+	}
+}
+
+// Ready returns true if the process is ready to serve dependents.  A
+// ProcessOneshot is only ready once it has completed, and a ProcessNotify
+// once it says so.  Others are ready as soon as they are started.  Either
+// way, probes with a SuccessThreshold must also be healthy.  This
+// implements the Readier interface.
+func (p *Process) Ready() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	switch p.kind {
+	case ProcessNotify:
+		if !p.ready {
+			return false
+		}
+	case ProcessOneshot:
+		return p.completed
+	}
+	for _, pr := range p.active {
+		if pr.gate && !pr.healthy {
+			return false
+		}
+	}
+	return true
+}
+
+// Completed returns true if a ProcessOneshot has run to completion.  This
+// implements the Completer interface.
+func (p *Process) Completed() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.completed
+}
@@ -0,0 +1,133 @@
+// Copyright 2016 The Govisor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package govisor
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gdamore/govisor/rest"
+)
+
+// LogQuery selects records from a log.  Zero fields select every record.
+type LogQuery struct {
+	Since   int64          // Only records after the one with this ID
+	From    time.Time      // Only records from this time onwards
+	To      time.Time      // Only records up to this time
+	Streams []string       // Only records on one of these streams
+	Level   string         // Least severe level of record to select
+	Match   string         // Only records whose text contains this
+	Regexp  *regexp.Regexp // Only records whose text matches this
+	Limit   int            // At most this many of the oldest records
+	Tail    int            // At most this many of the newest records
+}
+
+// IsZero returns true if the query selects every record.
+func (q *LogQuery) IsZero() bool {
+	return q.Since == 0 && q.From.IsZero() && q.To.IsZero() &&
+		len(q.Streams) == 0 && q.Level == "" && q.Match == "" &&
+		q.Regexp == nil && q.Limit <= 0 && q.Tail <= 0
+}
+
+// Matches returns true if the query selects the record, ignoring Limit
+// and Tail.  Records without a level, such as process output, are not
+// selected if a level is given.
+func (q *LogQuery) Matches(r *LogRecord) bool {
+	switch {
+	case r.Id <= q.Since:
+		return false
+	case !q.From.IsZero() && r.Time.Before(q.From):
+		return false
+	case !q.To.IsZero() && r.Time.After(q.To):
+		return false
+	case !rest.LevelAtLeast(r.Level, q.Level):
+		return false
+	case q.Match != "" && !strings.Contains(r.Text, q.Match):
+		return false
+	case q.Regexp != nil && !q.Regexp.MatchString(r.Text):
+		return false
+	}
+	return rest.MatchStream(r.Stream, q.Streams)
+}
+
+// Search returns the records that the query selects, in order, together
+// with the ID that GetRecords would return, or if Limit cut the records
+// short, the ID of the last one returned, so that searching after it
+// continues.  If the log has a journal, then the whole journal is
+// searched, otherwise just the records in memory.
+func (log *Log) Search(q LogQuery) ([]LogRecord, int64) {
+	log.lock()
+	id := log.id
+	mem := log.getRecords()
+	j := log.journal
+	log.unlock()
+
+	// The newest records alone need not scan the whole journal.
+	other := q
+	other.Tail = 0
+	if j != nil && q.Tail > 0 && other.IsZero() {
+		if recs, e := j.Tail(q.Tail); e == nil {
+			return recs, id
+		}
+	}
+
+	var recs []LogRecord
+	matched := 0
+	add := func(r LogRecord) bool {
+		if !q.To.IsZero() && r.Time.After(q.To) {
+			return false
+		}
+		if !q.Matches(&r) {
+			return true
+		}
+		recs = append(recs, r)
+		matched++
+		if q.Tail > 0 && len(recs) >= 2*q.Tail {
+			n := copy(recs, recs[len(recs)-q.Tail:])
+			recs = recs[:n]
+		}
+		return q.Limit <= 0 || matched < q.Limit
+	}
+	// As with ReadRecords, the journal has its own lock, and has every
+	// record that we have in memory.
+	if j == nil || j.Scan(q.Since, q.From, add) != nil {
+		recs = nil
+		matched = 0
+		for _, r := range mem {
+			if !add(r) {
+				break
+			}
+		}
+	}
+	if q.Tail > 0 && len(recs) > q.Tail {
+		recs = recs[len(recs)-q.Tail:]
+	} else if q.Limit > 0 && matched >= q.Limit {
+		id = recs[len(recs)-1].Id
+	}
+	return recs, id
+}
+
+// SearchLog returns the records from the service's log that the query
+// selects.  See Log.Search.
+func (s *Service) SearchLog(q LogQuery) ([]LogRecord, int64) {
+	return s.slog.Search(q)
+}
+
+// SearchLog returns the records from the manager's log that the query
+// selects.  See Log.Search.
+func (m *Manager) SearchLog(q LogQuery) ([]LogRecord, int64) {
+	return m.log.Search(q)
+}
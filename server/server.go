@@ -17,7 +17,9 @@ package server
 import (
 	"encoding/json"
 	"net/http"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -146,14 +148,19 @@ func (h *Handler) getService(w http.ResponseWriter, r *http.Request) {
 			Name:        svc.Name(),
 			Description: svc.Description(),
 			Enabled:     svc.Enabled(),
+			Masked:      svc.Masked(),
 			Running:     svc.Running(),
 			Failed:      svc.Failed(),
+			Completed:   svc.Completed(),
 			Provides:    svc.Provides(),
 			Depends:     svc.Depends(),
 			Conflicts:   svc.Conflicts(),
+			NextRestart: svc.NextRestart(),
+			Schedule:    svc.Schedule(),
 			Serial:      strconv.FormatInt(sn, 16),
 		}
 		info.Status, info.TimeStamp = svc.Status()
+		info.LastRun, info.NextRun, info.LastResult = svc.LastRun()
 		// check must be last
 		if newsn := svc.Serial(); sn == newsn {
 			break
@@ -180,7 +187,7 @@ func (h *Handler) enableService(w http.ResponseWriter, r *http.Request) {
 	if svc, e := h.findService(name); e != nil {
 		h.writeError(w, e)
 	} else if err := svc.Enable(); err != nil {
-		if e == govisor.ErrConflict {
+		if err == govisor.ErrConflict || err == govisor.ErrMasked {
 			e = &rest.Error{http.StatusConflict, err.Error()}
 		} else {
 			e = &rest.Error{http.StatusBadRequest, err.Error()}
@@ -217,6 +224,50 @@ func (h *Handler) restartService(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (h *Handler) reloadService(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["service"]
+	if svc, e := h.findService(name); e != nil {
+		h.writeError(w, e)
+	} else if err := svc.Reload(); err != nil {
+		e = &rest.Error{http.StatusBadRequest, err.Error()}
+		h.writeError(w, e)
+	} else {
+		h.writeJson(w, ok)
+	}
+}
+
+// signalService sends the signal named by the "signal" parameter to the
+// service.  The "target" parameter is "pid" (the default) for the main
+// process, or "group" for its whole process group.
+func (h *Handler) signalService(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["service"]
+	q := r.URL.Query()
+	sig, err := govisor.ParseSignal(q.Get("signal"))
+	if err != nil {
+		h.writeError(w, &rest.Error{http.StatusBadRequest, err.Error()})
+		return
+	}
+	group := false
+	switch q.Get("target") {
+	case "", "pid":
+	case "group":
+		group = true
+	default:
+		h.writeError(w, &rest.Error{http.StatusBadRequest, "Bad target"})
+		return
+	}
+	if svc, e := h.findService(name); e != nil {
+		h.writeError(w, e)
+	} else if err := svc.Signal(sig, group); err != nil {
+		e = &rest.Error{http.StatusBadRequest, err.Error()}
+		h.writeError(w, e)
+	} else {
+		h.writeJson(w, ok)
+	}
+}
+
 func (h *Handler) clearService(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	name := vars["service"]
@@ -228,6 +279,38 @@ func (h *Handler) clearService(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (h *Handler) maskService(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["service"]
+	if svc, e := h.findService(name); e != nil {
+		h.writeError(w, e)
+	} else if err := svc.Mask(); err != nil {
+		e = &rest.Error{
+			Code:    http.StatusBadRequest,
+			Message: err.Error(),
+		}
+		h.writeError(w, e)
+	} else {
+		h.writeJson(w, ok)
+	}
+}
+
+func (h *Handler) unmaskService(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["service"]
+	if svc, e := h.findService(name); e != nil {
+		h.writeError(w, e)
+	} else if err := svc.Unmask(); err != nil {
+		e = &rest.Error{
+			Code:    http.StatusBadRequest,
+			Message: err.Error(),
+		}
+		h.writeError(w, e)
+	} else {
+		h.writeJson(w, ok)
+	}
+}
+
 func (h *Handler) getLog(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	name := vars["service"]
@@ -235,35 +318,134 @@ func (h *Handler) getLog(w http.ResponseWriter, r *http.Request) {
 		h.writeError(w, e)
 	} else {
 		h.checkPoll(r, svc.WatchLog)
-		recs, sn := svc.GetLog(0)
-		jrecs := make([]rest.LogRecord, len(recs))
-		when := time.Now()
-		for i := range recs {
-			jrecs[i].Id = strconv.FormatInt(recs[i].Id, 16)
-			jrecs[i].Time = recs[i].Time
-			jrecs[i].Text = recs[i].Text
-			when = jrecs[i].Time
+		h.writeLog(w, r, svc)
+	}
+}
+
+func (h *Handler) getManagerLog(w http.ResponseWriter, r *http.Request) {
+	h.checkPoll(r, h.m.WatchLog)
+	h.writeLog(w, r, h.m)
+}
+
+// logSource is a log that can be read and searched, that of either a
+// service or the manager.
+type logSource interface {
+	ReadLog(since int64, limit int) ([]govisor.LogRecord, int64, bool)
+	SearchLog(q govisor.LogQuery) ([]govisor.LogRecord, int64)
+}
+
+// badRequest writes an error for a bad query parameter.
+func (h *Handler) badRequest(w http.ResponseWriter, msg string) {
+	h.writeError(w, &rest.Error{
+		Code:    http.StatusBadRequest,
+		Message: msg,
+	})
+}
+
+// parseLogQuery parses the query parameters of a GET of a log.  If it
+// fails, then it writes the error, and returns false.
+func (h *Handler) parseLogQuery(w http.ResponseWriter, r *http.Request,
+	lq *govisor.LogQuery) bool {
+	var e error
+	q := r.URL.Query()
+	if v := q.Get("since"); v != "" {
+		if lq.Since, e = strconv.ParseInt(v, 16, 64); e != nil {
+			h.badRequest(w, "Bad since")
+			return false
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if lq.Limit, e = strconv.Atoi(v); e != nil || lq.Limit < 0 {
+			h.badRequest(w, "Bad limit")
+			return false
+		}
+	}
+	if v := q.Get("tail"); v != "" {
+		if lq.Tail, e = strconv.Atoi(v); e != nil || lq.Tail < 0 {
+			h.badRequest(w, "Bad tail")
+			return false
 		}
-		etag := "\"" + strconv.FormatInt(sn, 16) + "\""
-		if !h.condCheckGet(w, r, etag, when) {
-			return
+	}
+	if v := q.Get("from"); v != "" {
+		if lq.From, e = time.Parse(time.RFC3339Nano, v); e != nil {
+			h.badRequest(w, "Bad from")
+			return false
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if lq.To, e = time.Parse(time.RFC3339Nano, v); e != nil {
+			h.badRequest(w, "Bad to")
+			return false
+		}
+	}
+	if v := q.Get("stream"); v != "" {
+		for _, s := range strings.Split(v, ",") {
+			switch s {
+			case rest.StreamStdout, rest.StreamStderr,
+				rest.StreamSupervisor:
+				lq.Streams = append(lq.Streams, s)
+			default:
+				h.badRequest(w, "Bad stream")
+				return false
+			}
 		}
-		w.Header().Set("Etag", etag)
-		w.Header().Set("Last-Modified", when.Format(http.TimeFormat))
-		h.writeJson(w, jrecs)
 	}
+	switch v := q.Get("level"); v {
+	case "", rest.LevelInfo, rest.LevelWarning, rest.LevelError:
+		lq.Level = v
+	default:
+		h.badRequest(w, "Bad level")
+		return false
+	}
+	lq.Match = q.Get("match")
+	if v := q.Get("regexp"); v != "" {
+		if lq.Regexp, e = regexp.Compile(v); e != nil {
+			h.badRequest(w, "Bad regexp: "+e.Error())
+			return false
+		}
+	}
+	return true
 }
 
-func (h *Handler) getManagerLog(w http.ResponseWriter, r *http.Request) {
-	m := h.m
-	h.checkPoll(r, m.WatchLog)
-	recs, sn := m.GetLog(0)
+// writeLog writes the records of a log.  The query parameter since is the
+// ID of the last record the client has, and limit is the most records to
+// return.  If records after since are no longer kept, then the LogLost
+// header is set.  The log is searched instead if any of the following are
+// given: from and to, which bound the time of the records, in RFC 3339
+// format; stream, a comma separated list of streams; level, the least
+// severe level; match, text that the records must contain; regexp, a
+// regular expression that they must match; and tail, the most records to
+// return, counting back from the newest.  If limit, or the most that are
+// read at once, leaves more records to come, then the Etag is the ID of
+// the last record returned, so that polling with it returns the rest.
+func (h *Handler) writeLog(w http.ResponseWriter, r *http.Request,
+	src logSource) {
+	var lq govisor.LogQuery
+	if !h.parseLogQuery(w, r, &lq) {
+		return
+	}
+	var recs []govisor.LogRecord
+	var sn int64
+	var lost bool
+	// Since and limit alone are an ordinary read, anything more a search.
+	search := lq
+	search.Since = 0
+	search.Limit = 0
+	if !search.IsZero() {
+		recs, sn = src.SearchLog(lq)
+	} else {
+		recs, sn, lost = src.ReadLog(lq.Since, lq.Limit)
+	}
 	jrecs := make([]rest.LogRecord, len(recs))
 	when := time.Now()
 	for i := range recs {
 		jrecs[i].Id = strconv.FormatInt(recs[i].Id, 16)
 		jrecs[i].Time = recs[i].Time
 		jrecs[i].Text = recs[i].Text
+		jrecs[i].Service = recs[i].Service
+		jrecs[i].Stream = recs[i].Stream
+		jrecs[i].Level = recs[i].Level
+		jrecs[i].Pid = recs[i].Pid
 		when = jrecs[i].Time
 	}
 	etag := "\"" + strconv.FormatInt(sn, 16) + "\""
@@ -272,6 +454,9 @@ func (h *Handler) getManagerLog(w http.ResponseWriter, r *http.Request) {
 	}
 	w.Header().Set("Etag", etag)
 	w.Header().Set("Last-Modified", when.Format(http.TimeFormat))
+	if lost {
+		w.Header().Set(rest.LogLostHeader, "true")
+	}
 	h.writeJson(w, jrecs)
 }
 
@@ -295,6 +480,46 @@ func (h *Handler) getManager(w http.ResponseWriter, r *http.Request) {
 	h.writeJson(w, i)
 }
 
+func (h *Handler) reload(w http.ResponseWriter, r *http.Request) {
+	if err := h.m.Reload(); err != nil {
+		h.writeError(w, &rest.Error{
+			Code:    http.StatusBadRequest,
+			Message: err.Error(),
+		})
+	} else {
+		h.writeJson(w, ok)
+	}
+}
+
+func (h *Handler) instantiate(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if _, err := h.m.Instantiate(vars["instance"]); err != nil {
+		h.writeError(w, instanceError(err))
+	} else {
+		h.writeJson(w, ok)
+	}
+}
+
+func (h *Handler) uninstantiate(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if err := h.m.Uninstantiate(vars["instance"]); err != nil {
+		h.writeError(w, instanceError(err))
+	} else {
+		h.writeJson(w, ok)
+	}
+}
+
+func instanceError(err error) *rest.Error {
+	code := http.StatusBadRequest
+	switch err {
+	case govisor.ErrNoTemplate, govisor.ErrNoInstance:
+		code = http.StatusNotFound
+	case govisor.ErrNameExists:
+		code = http.StatusConflict
+	}
+	return &rest.Error{Code: code, Message: err.Error()}
+}
+
 func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	h.r.ServeHTTP(w, req)
 }
@@ -304,12 +529,19 @@ func NewHandler(m *govisor.Manager) *Handler {
 	h := &Handler{m: m, r: r}
 	r.HandleFunc("/", h.getManager).Methods("GET")
 	r.HandleFunc("/log", h.getManagerLog).Methods("GET")
+	r.HandleFunc("/reload", h.reload).Methods("POST")
+	r.HandleFunc("/instantiate/{instance}", h.instantiate).Methods("POST")
+	r.HandleFunc("/uninstantiate/{instance}", h.uninstantiate).Methods("POST")
 	r.HandleFunc("/services", h.listServices).Methods("GET")
 	r.HandleFunc("/services/{service}", h.getService).Methods("GET")
 	r.HandleFunc("/services/{service}/enable", h.enableService).Methods("POST")
 	r.HandleFunc("/services/{service}/disable", h.disableService).Methods("POST")
 	r.HandleFunc("/services/{service}/clear", h.clearService).Methods("POST")
 	r.HandleFunc("/services/{service}/restart", h.restartService).Methods("POST")
+	r.HandleFunc("/services/{service}/reload", h.reloadService).Methods("POST")
+	r.HandleFunc("/services/{service}/signal", h.signalService).Methods("POST")
+	r.HandleFunc("/services/{service}/mask", h.maskService).Methods("POST")
+	r.HandleFunc("/services/{service}/unmask", h.unmaskService).Methods("POST")
 	r.HandleFunc("/services/{service}/log", h.getLog).Methods("GET")
 	return h
 }
@@ -16,7 +16,10 @@ package govisor
 
 import (
 	"log"
+	"math"
+	"math/rand"
 	"strings"
+	"syscall"
 	"time"
 )
 
@@ -69,10 +72,19 @@ type Service struct {
 	conflicts  []string
 	provides   []string
 	enabled    bool
+	masked     bool
 	running    bool
+	ready      bool
 	stopping   bool
 	failed     bool
-	restart    bool
+	restart    RestartMode
+	exhausted  bool
+	schedule   Schedule
+	due        bool // Set while starting a scheduled run
+	inRun      bool // A scheduled run has not yet finished
+	lastRun    time.Time
+	nextRun    time.Time
+	lastResult string
 	checking   bool
 	healthy    bool
 	err        error
@@ -87,10 +99,18 @@ type Service struct {
 	rateLimit  int
 	ratePeriod time.Duration
 	startTimes []time.Time
+	backoff    backoff
+	maxRestart int
 	notify     func()
 	slog       *Log
 	mlog       *MultiLogger
 	serial     int64
+
+	logFile     string
+	logRotation LogRotation
+	lfile       *LogFile
+	flogger     *log.Logger // Writes to lfile
+	journal     *Journal    // Keeps slog on disk, if the manager has one
 }
 
 // The service name.  This takes either the form <base> or <base>:<variant>.
@@ -135,6 +155,40 @@ func (s *Service) Serial() int64 {
 	return rv
 }
 
+// NextRestart returns the time at which the next automatic restart will
+// be attempted, or the zero time if there is no restart pending.
+func (s *Service) NextRestart() time.Time {
+	if m := s.mgr; m != nil {
+		m.lock()
+		defer m.unlock()
+	}
+	return s.backoff.next
+}
+
+// Schedule returns the schedule for the service, or the empty string if it
+// is not a scheduled service.
+func (s *Service) Schedule() string {
+	if m := s.mgr; m != nil {
+		m.lock()
+		defer m.unlock()
+	}
+	if s.schedule == nil {
+		return ""
+	}
+	return s.schedule.String()
+}
+
+// LastRun returns the time the most recent scheduled run was started, the
+// time of the next scheduled run, and the result of the most recent run.
+// The times are zero if there is no such run.
+func (s *Service) LastRun() (time.Time, time.Time, string) {
+	if m := s.mgr; m != nil {
+		m.lock()
+		defer m.unlock()
+	}
+	return s.lastRun, s.nextRun, s.lastResult
+}
+
 // Status returns the most reason status message, and the time when the
 // status was recorded.
 func (s *Service) Status() (string, time.Time) {
@@ -198,6 +252,48 @@ func (s *Service) Running() bool {
 	}
 }
 
+// Masked returns true if the service is masked.
+func (s *Service) Masked() bool {
+	if m := s.mgr; m == nil {
+		return s.masked
+	} else {
+		m.lock()
+		rv := s.masked
+		m.unlock()
+		return rv
+	}
+}
+
+// Ready checks if a service is running and ready.  Services that depend
+// upon this one are only started once it is ready.  Most providers are
+// ready as soon as they are started, but see the Readier interface.
+func (s *Service) Ready() bool {
+	if m := s.mgr; m == nil {
+		return false
+	} else {
+		m.lock()
+		rv := s.running && s.ready && !s.stopping
+		m.unlock()
+		return rv
+	}
+}
+
+// Completed checks if a service has run to completion.  This is only
+// possible for providers that implement the Completer interface.
+func (s *Service) Completed() bool {
+	if m := s.mgr; m == nil {
+		return false
+	} else {
+		m.lock()
+		rv := false
+		if c, ok := s.prov.(Completer); ok && s.running && !s.stopping {
+			rv = c.Completed()
+		}
+		m.unlock()
+		return rv
+	}
+}
+
 // Failed returns true if the service is in a failure state.
 func (s *Service) Failed() bool {
 	if m := s.mgr; m == nil {
@@ -219,14 +315,19 @@ func (s *Service) Enable() error {
 	}
 	s.mgr.lock()
 	defer s.mgr.unlock()
+	defer s.mgr.saveState()
 
 	if s.enabled {
 		return nil
 	}
+	if s.masked {
+		s.errorf("Cannot enable %s: service is masked", s.Name())
+		return ErrMasked
+	}
 
 	for c := range s.incompat {
 		if c.enabled {
-			s.logf("Cannot enable %s: conflicts with %s",
+			s.errorf("Cannot enable %s: conflicts with %s",
 				s.Name(), c.Name())
 			s.reason = "Disabled due to conflict"
 			s.serial = s.mgr.bumpSerial()
@@ -240,6 +341,14 @@ func (s *Service) Enable() error {
 	s.logf("Enabling service %s", s.Name())
 	s.enabled = true
 	s.starts = 0
+	s.resetRestart()
+	if s.schedule != nil {
+		s.reason = "Waiting for schedule"
+		s.nextRun = s.schedule.Next(s.stamp)
+		s.logf("Next run of %s at %s", s.Name(),
+			s.nextRun.Format(time.RFC3339))
+		return nil
+	}
 	s.startRecurse("Enabled service")
 	return nil
 }
@@ -253,11 +362,17 @@ func (s *Service) Disable() error {
 	}
 	s.mgr.lock()
 	defer s.mgr.unlock()
+	defer s.mgr.saveState()
 
-	if !s.enabled && s.reason == "Disabled" {
+	if !s.enabled && (s.reason == "Disabled" || s.masked) {
 		return nil
 	}
+	s.disable()
+	return nil
+}
 
+// disable disables the service.  Call with lock held.
+func (s *Service) disable() {
 	s.serial = s.mgr.bumpSerial()
 	s.logf("Disabling service %s", s.Name())
 	s.stamp = time.Now()
@@ -265,7 +380,54 @@ func (s *Service) Disable() error {
 	s.enabled = false
 	s.failed = false
 	s.err = nil
+	s.nextRun = time.Time{}
+	s.resetRestart()
 	s.stopRecurse("Disabled")
+}
+
+// Mask disables the service, and prevents it from being enabled again until
+// it is unmasked.  This is useful to keep a service that is broken, or
+// not wanted on this system, from being started by accident.
+func (s *Service) Mask() error {
+	if s.mgr == nil {
+		return ErrNoManager
+	}
+	s.mgr.lock()
+	defer s.mgr.unlock()
+	defer s.mgr.saveState()
+
+	if s.masked {
+		return nil
+	}
+	if s.enabled {
+		s.disable()
+	}
+	s.serial = s.mgr.bumpSerial()
+	s.logf("Masking service %s", s.Name())
+	s.stamp = time.Now()
+	s.reason = "Masked"
+	s.masked = true
+	return nil
+}
+
+// Unmask removes the mask from a service, so that it can be enabled.  It
+// does not enable the service.
+func (s *Service) Unmask() error {
+	if s.mgr == nil {
+		return ErrNoManager
+	}
+	s.mgr.lock()
+	defer s.mgr.unlock()
+	defer s.mgr.saveState()
+
+	if !s.masked {
+		return nil
+	}
+	s.serial = s.mgr.bumpSerial()
+	s.logf("Unmasking service %s", s.Name())
+	s.stamp = time.Now()
+	s.reason = "Disabled"
+	s.masked = false
 	return nil
 }
 
@@ -278,6 +440,7 @@ func (s *Service) Restart() error {
 
 	s.mgr.lock()
 	defer s.mgr.unlock()
+	defer s.mgr.saveState()
 
 	if !s.enabled {
 		return nil
@@ -291,10 +454,14 @@ func (s *Service) Restart() error {
 	s.stamp = time.Now()
 	s.reason = "Restarting"
 	s.starts = 0
+	s.resetRestart()
 	s.failed = false
 	s.err = nil
 	s.enabled = true
+	// For scheduled services, this is an unscheduled run.
+	s.due = true
 	s.startRecurse("Restarting")
+	s.due = false
 	return nil
 }
 
@@ -307,6 +474,7 @@ func (s *Service) Clear() {
 	}
 	s.mgr.lock()
 	defer s.mgr.unlock()
+	defer s.mgr.saveState()
 
 	s.serial = s.mgr.bumpSerial()
 	if s.failed {
@@ -315,11 +483,66 @@ func (s *Service) Clear() {
 		s.logf("Clearing fault on %s", s.Name())
 	}
 	s.starts = 0
+	s.resetRestart()
 	s.failed = false
 	s.err = nil
 	s.startRecurse("Cleared fault")
 }
 
+// Reload asks a running service to reload its configuration, without
+// restarting it.  The provider must implement Reloader.
+func (s *Service) Reload() error {
+	r, ok := s.prov.(Reloader)
+	if !ok {
+		return ErrNoReload
+	}
+	if s.mgr == nil {
+		return ErrNoManager
+	}
+	s.mgr.lock()
+	if !s.running || s.stopping {
+		s.mgr.unlock()
+		return ErrNotRunning
+	}
+	s.logf("Reloading service %s", s.Name())
+	s.mgr.unlock()
+
+	// The reload may take a while, so we do not hold the lock for it.
+	if e := r.Reload(); e != nil {
+		s.errorf("Failed to reload %s: %v", s.Name(), e)
+		return e
+	}
+	return nil
+}
+
+// Signal sends a signal to a running service, or if group is true, to its
+// whole process group.  The provider must implement Signaler.
+func (s *Service) Signal(sig syscall.Signal, group bool) error {
+	sg, ok := s.prov.(Signaler)
+	if !ok {
+		return ErrNoSignal
+	}
+	if s.mgr == nil {
+		return ErrNoManager
+	}
+	s.mgr.lock()
+	defer s.mgr.unlock()
+	if !s.running || s.stopping {
+		return ErrNotRunning
+	}
+	if group {
+		s.logf("Sending %s to process group of %s", SignalName(sig),
+			s.Name())
+	} else {
+		s.logf("Sending %s to %s", SignalName(sig), s.Name())
+	}
+	if e := sg.Signal(sig, group); e != nil {
+		s.errorf("Failed to signal %s: %v", s.Name(), e)
+		return e
+	}
+	return nil
+}
+
 // Check checks if a service is running, and performs any appropriate health
 // checks.  It returns nil if the service is running and healthy, or false
 // otherwise.  If it returns false, it will stop the service, as well as
@@ -374,7 +597,7 @@ func (s *Service) SetProperty(n PropertyName, v interface{}) error {
 		defer m.unlock()
 	}
 	if e := s.setProp(n, v); e != nil {
-		s.logf("Failed to set property %s: %v", s.Name(), e)
+		s.errorf("Failed to set property %s: %v", s.Name(), e)
 		return e
 	}
 	return nil
@@ -413,9 +636,44 @@ func (s *Service) setProp(n PropertyName, v interface{}) error {
 		} else {
 			return ErrBadPropType
 		}
+	case PropLogFile:
+		if v, ok := v.(string); ok {
+			s.logFile = v
+			s.openLogFile()
+		} else {
+			return ErrBadPropType
+		}
+	case PropLogRotation:
+		if v, ok := v.(LogRotation); ok {
+			s.logRotation = v
+			if s.lfile != nil {
+				s.openLogFile()
+			}
+		} else {
+			return ErrBadPropType
+		}
 	case PropRestart:
-		if v, ok := v.(bool); ok {
-			s.restart = v
+		switch mode := v.(type) {
+		case bool:
+			if mode {
+				s.restart = RestartOnFailure
+			} else {
+				s.restart = RestartNever
+			}
+		case RestartMode:
+			if mode, e := ParseRestartMode(string(mode)); e != nil {
+				return e
+			} else {
+				s.restart = mode
+			}
+		default:
+			return ErrBadPropType
+		}
+		// Providers only ever see the RestartMode.
+		v = s.restart
+	case PropRestartAttempts:
+		if v, ok := v.(int); ok {
+			s.maxRestart = v
 		} else {
 			return ErrBadPropType
 		}
@@ -438,6 +696,60 @@ func (s *Service) setProp(n PropertyName, v interface{}) error {
 		} else {
 			return ErrBadPropType
 		}
+	case PropRestartDelay:
+		if v, ok := v.(time.Duration); ok {
+			s.backoff.delay = v
+		} else {
+			return ErrBadPropType
+		}
+	case PropRestartMaxDelay:
+		if v, ok := v.(time.Duration); ok {
+			s.backoff.maxDelay = v
+		} else {
+			return ErrBadPropType
+		}
+	case PropRestartMultiplier:
+		if v, ok := v.(float64); ok {
+			s.backoff.multiplier = v
+		} else {
+			return ErrBadPropType
+		}
+	case PropRestartJitter:
+		if v, ok := v.(float64); ok {
+			s.backoff.jitter = v
+		} else {
+			return ErrBadPropType
+		}
+	case PropRestartResetAfter:
+		if v, ok := v.(time.Duration); ok {
+			s.backoff.resetAfter = v
+		} else {
+			return ErrBadPropType
+		}
+	case PropSchedule:
+		switch v := v.(type) {
+		case string:
+			if v == "" {
+				s.schedule = nil
+				break
+			}
+			sched, e := ParseSchedule(v)
+			if e != nil {
+				return ErrBadPropValue
+			}
+			s.schedule = sched
+		case Schedule:
+			s.schedule = v
+		case nil:
+			s.schedule = nil
+		default:
+			return ErrBadPropType
+		}
+		if s.enabled && s.schedule != nil {
+			s.nextRun = s.schedule.Next(time.Now())
+		} else {
+			s.nextRun = time.Time{}
+		}
 	case PropName:
 		if v, ok := v.(string); ok {
 			s.name = v
@@ -496,12 +808,30 @@ func (s *Service) GetProperty(n PropertyName) (interface{}, error) {
 	switch n {
 	case PropLogger:
 		return s.logger, nil
+	case PropLogFile:
+		return s.logFile, nil
+	case PropLogRotation:
+		return s.logRotation, nil
 	case PropRestart:
 		return s.restart, nil
 	case PropRateLimit:
 		return s.rateLimit, nil
 	case PropRatePeriod:
 		return s.ratePeriod, nil
+	case PropRestartDelay:
+		return s.backoff.delay, nil
+	case PropRestartMaxDelay:
+		return s.backoff.maxDelay, nil
+	case PropRestartMultiplier:
+		return s.backoff.multiplier, nil
+	case PropRestartJitter:
+		return s.backoff.jitter, nil
+	case PropRestartResetAfter:
+		return s.backoff.resetAfter, nil
+	case PropRestartAttempts:
+		return s.maxRestart, nil
+	case PropSchedule:
+		return s.schedule, nil
 	case PropName:
 		return s.name, nil
 	case PropDescription:
@@ -526,6 +856,12 @@ func (s *Service) GetLog(lastid int64) ([]LogRecord, int64) {
 	return s.slog.GetRecords(lastid)
 }
 
+// ReadLog returns up to limit records from the service's log, after the
+// one with ID since.  See Log.ReadRecords.
+func (s *Service) ReadLog(since int64, limit int) ([]LogRecord, int64, bool) {
+	return s.slog.ReadRecords(since, limit)
+}
+
 // setManager is called by the framework when the service is added to
 // the manager.  This calculates the various dependency graphs, updating
 // links to other services in the manager.
@@ -536,6 +872,8 @@ func (s *Service) setManager(mgr *Manager) {
 	}
 	s.mlog.AddLogger(mgr.getLogger(s))
 	s.mgr = mgr
+	s.openLogFile()
+	s.openJournal()
 
 	s.incompat = make(map[*Service]bool)
 	s.children = make(map[*Service]bool)
@@ -591,6 +929,8 @@ func (s *Service) delManager() {
 
 	// remove the item
 	delete(s.mgr.services, s)
+	s.closeLogFile()
+	s.closeJournal()
 
 	// remove from each of our conflicts
 	for c := range s.incompat {
@@ -624,10 +964,24 @@ func (s *Service) logf(fmt string, v ...interface{}) {
 	s.mlog.Logger().Printf(fmt, v...)
 }
 
+// warnf logs a warning about the service.
+func (s *Service) warnf(format string, v ...interface{}) {
+	logLevelf(s.mlog.Logger(), LevelWarning, format, v...)
+}
+
+// errorf logs an error about the service.
+func (s *Service) errorf(format string, v ...interface{}) {
+	logLevelf(s.mlog.Logger(), LevelError, format, v...)
+}
+
 func (s *Service) startRecurse(detail string) {
 	if s.running {
 		return
 	}
+	if s.schedule != nil && !s.due {
+		// Scheduled services are only started by the scheduler.
+		return
+	}
 	if !s.canRun() {
 		return
 	}
@@ -640,18 +994,34 @@ func (s *Service) startRecurse(detail string) {
 	s.starts++
 	s.serial = s.mgr.bumpSerial()
 	if e := s.prov.Start(); e != nil {
-		s.logf("Failed to start %s: %v", s.Name(), e)
+		s.errorf("Failed to start %s: %v", s.Name(), e)
 		s.reason = "Failed start:" + e.Error()
 		s.stamp = time.Now()
 		s.err = e
 		s.failed = true
+		if s.schedule != nil {
+			s.lastRun = s.stamp
+			s.lastResult = s.reason
+		}
 		return
 	}
 	s.reason = "Started"
 	s.stamp = time.Now()
+	s.backoff.started = s.stamp
 	s.logf("Started %s: %s", s.Name(), detail)
+	if s.schedule != nil {
+		s.lastRun = s.stamp
+		s.lastResult = "Running"
+		s.inRun = true
+	}
 	s.running = true
 	s.failed = false
+	if r, ok := s.prov.(Readier); ok && !r.Ready() {
+		s.ready = false
+		s.reason = "Started, waiting for ready"
+		return
+	}
+	s.ready = true
 	for child := range s.children {
 		child.startRecurse("Dependency running")
 	}
@@ -669,12 +1039,14 @@ func (s *Service) stopRecurse(detail string) {
 		child.stopRecurse("Unmet dependency")
 	}
 	s.serial = s.mgr.bumpSerial()
+	s.endRun(detail)
 	s.prov.Stop()
 	s.reason = detail
 	s.stamp = time.Now()
 	s.logf("Stopped %s: %s", s.Name(), detail)
 
 	s.running = false
+	s.ready = false
 	s.stopping = false
 }
 
@@ -685,7 +1057,8 @@ func (s *Service) canRun() bool {
 	for _, deps := range s.parents {
 		sat := false
 		for d := range deps {
-			if d.enabled && d.running && !d.stopping && !d.failed {
+			if d.enabled && d.running && d.ready &&
+				!d.stopping && !d.failed {
 				sat = true
 				break
 			}
@@ -718,17 +1091,48 @@ func (s *Service) checkService() error {
 	s.checking = true
 	if e := s.prov.Check(); e != nil {
 		s.serial = s.mgr.bumpSerial()
-		s.logf("Service %s faulted: %v", s.Name(), e)
+		s.errorf("Service %s faulted: %v", s.Name(), e)
+		s.endRun("Failed: " + e.Error())
 		s.failed = true
 		s.stopRecurse("Faulted: " + e.Error())
 		s.err = e
 		s.checking = false
 		return e
 	}
-	if s.reason != "Healthy" {
+	if !s.ready {
+		if r, ok := s.prov.(Readier); ok && !r.Ready() {
+			s.checking = false
+			return nil
+		}
 		s.serial = s.mgr.bumpSerial()
-		s.reason = "Healthy"
-		s.logf("Service healthy")
+		s.logf("Service %s ready", s.Name())
+		s.ready = true
+		for child := range s.children {
+			child.startRecurse("Dependency ready")
+		}
+	}
+	status := "Healthy"
+	if hr, ok := s.prov.(HealthReporter); ok {
+		if h := hr.Health(); h != "" {
+			status += " (" + h + ")"
+		}
+	}
+	if c, ok := s.prov.(Completer); ok && c.Completed() {
+		status = "Completed"
+	}
+	if s.backoff.stable() {
+		s.logf("Service stable, resetting restart backoff")
+		s.backoff.attempts = 0
+	}
+	if s.reason != status {
+		s.serial = s.mgr.bumpSerial()
+		if status == "Completed" {
+			s.endRun(status)
+			s.logf("Service %s completed", s.Name())
+		} else if !strings.HasPrefix(s.reason, "Healthy") {
+			s.logf("Service healthy")
+		}
+		s.reason = status
 		s.stamp = time.Now()
 	}
 	s.checking = false
@@ -757,7 +1161,7 @@ func (s *Service) tooQuickly() error {
 
 		// Log it if not already done.
 		if !s.rateLog {
-			s.logf("Service %s restarting too quickly", s.Name())
+			s.warnf("Service %s restarting too quickly", s.Name())
 		}
 		// And we uncoditionally mark this to note cool down.
 		s.rateLog = true
@@ -782,13 +1186,140 @@ func (s *Service) tooQuickly() error {
 	return nil
 }
 
+// backoff tracks the exponential backoff used when automatically restarting
+// a failed service.  The first restart after a failure is attempted
+// immediately.  Each further consecutive attempt waits longer, starting with
+// delay and growing by multiplier each time, up to maxDelay.  The delay is
+// randomly adjusted by up to +/- jitter (a fraction), so that services that
+// fail together do not all restart together.  Once the service has been
+// running for resetAfter, the attempt count is reset.  A zero delay disables
+// the backoff, leaving only the rate limit.
+type backoff struct {
+	delay      time.Duration
+	maxDelay   time.Duration
+	multiplier float64
+	jitter     float64
+	resetAfter time.Duration
+	attempts   int       // consecutive restart attempts
+	next       time.Time // when the next attempt is due, if pending
+	started    time.Time // when the service was last started
+}
+
+func (b *backoff) reset() {
+	b.attempts = 0
+	b.next = time.Time{}
+}
+
+// wait returns the time to wait before the next attempt.
+func (b *backoff) wait() time.Duration {
+	if b.attempts == 0 || b.delay <= 0 {
+		return 0
+	}
+	mult := b.multiplier
+	if mult < 1 {
+		mult = 1
+	}
+	d := float64(b.delay) * math.Pow(mult, float64(b.attempts-1))
+	if b.maxDelay > 0 && d > float64(b.maxDelay) {
+		d = float64(b.maxDelay)
+	}
+	if b.jitter > 0 {
+		d += d * b.jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(d)
+}
+
+// stable returns true if the attempt count should be reset, because the
+// service has been running long enough since the last restart.
+func (b *backoff) stable() bool {
+	if b.attempts == 0 || b.resetAfter <= 0 {
+		return false
+	}
+	return time.Since(b.started) >= b.resetAfter
+}
+
+// resetRestart resets the restart policy, for example when an operator
+// clears a fault.  It takes the service out of the terminal failed state.
+func (s *Service) resetRestart() {
+	s.backoff.reset()
+	s.exhausted = false
+}
+
 func (s *Service) selfHeal() {
-	if s.failed && s.restart {
-		s.logf("Attempting self-healing")
-		s.startRecurse("Self-healing attempt")
+	if !s.failed || s.exhausted || !s.restart.wants(s.err) {
+		return
+	}
+	if s.maxRestart > 0 && s.backoff.attempts >= s.maxRestart {
+		// Terminal failure, until the operator intervenes.
+		s.exhausted = true
+		s.serial = s.mgr.bumpSerial()
+		s.stamp = time.Now()
+		s.reason = "Restart limit reached"
+		if s.err != nil {
+			s.reason += ": " + s.err.Error()
+		}
+		s.errorf("Service %s failed after %d restart attempts",
+			s.Name(), s.backoff.attempts)
+		return
+	}
+	now := time.Now()
+	if s.backoff.next.IsZero() {
+		d := s.backoff.wait()
+		s.backoff.next = now.Add(d)
+		if d > 0 {
+			s.serial = s.mgr.bumpSerial()
+			s.logf("Restarting %s in %v", s.Name(),
+				d-d%time.Millisecond)
+		}
+	}
+	if now.Before(s.backoff.next) {
+		return
+	}
+	s.backoff.next = time.Time{}
+	s.backoff.attempts++
+	s.logf("Attempting self-healing")
+	s.due = true
+	s.startRecurse("Self-healing attempt")
+	s.due = false
+}
+
+// endRun records the result of a scheduled run, if one is in progress.
+func (s *Service) endRun(result string) {
+	if s.inRun {
+		s.inRun = false
+		s.lastResult = result
 	}
 }
 
+// runSchedule starts a scheduled service if it is due.  Runs that would
+// overlap with one still in progress are skipped.  A completed run is
+// stopped quietly, so that dependents are not disturbed.  Call with the
+// manager lock held.
+func (s *Service) runSchedule(now time.Time) {
+	if s.schedule == nil || !s.enabled || s.nextRun.IsZero() ||
+		now.Before(s.nextRun) {
+		return
+	}
+	s.serial = s.mgr.bumpSerial()
+	s.nextRun = s.schedule.Next(now)
+	if s.running && !s.stopping {
+		if c, ok := s.prov.(Completer); !ok || !c.Completed() {
+			s.warnf("Skipping scheduled run of %s: still running",
+				s.Name())
+			return
+		}
+		s.prov.Stop()
+		s.running = false
+		s.ready = false
+	}
+	s.failed = false
+	s.err = nil
+	s.resetRestart()
+	s.due = true
+	s.startRecurse("Scheduled run")
+	s.due = false
+}
+
 func (s *Service) doNotify() {
 	go func() {
 		var cb func()
@@ -811,6 +1342,7 @@ func (s *Service) doNotify() {
 // Service interface to applications.
 func NewService(p Provider) *Service {
 	s := &Service{prov: p}
+	s.restart = RestartNever
 	s.ratePeriod = time.Minute
 	s.rateLimit = 10
 	s.startTimes = make([]time.Time, s.rateLimit)
@@ -821,7 +1353,7 @@ func NewService(p Provider) *Service {
 	s.depends = append([]string{}, p.Depends()...)
 	s.provides = append([]string{}, p.Provides()...)
 	s.mlog = NewMultiLogger()
-	s.mlog.Logger().SetPrefix("[" + s.Name() + "] ")
+	s.mlog.SetService(s.Name())
 	s.prov.SetProperty(PropLogger, s.mlog.Logger())
 	s.slog = NewLog()
 	s.mlog.AddLogger(log.New(s.slog, "", 0))
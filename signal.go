@@ -0,0 +1,113 @@
+// Copyright 2016 The Govisor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package govisor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// StopStep is a step in stopping a process: a signal to send, and how long
+// to wait for the process to exit before going on to the next step.  Only
+// the last step may have no wait, in which case we wait for as long as it
+// takes.
+type StopStep struct {
+	Signal string   `json:"signal" yaml:"signal" toml:"signal"`
+	Wait   Duration `json:"wait" yaml:"wait" toml:"wait"`
+}
+
+// stopStep is a StopStep, with the signal resolved.  If cmd is set, the
+// stop command is run instead of sending a signal.
+type stopStep struct {
+	sig  syscall.Signal
+	name string
+	wait time.Duration
+	cmd  bool
+}
+
+// ParseSignal returns the signal with the given name, such as "SIGTERM"
+// or "TERM", or number.
+func ParseSignal(name string) (syscall.Signal, error) {
+	s := strings.ToUpper(strings.TrimSpace(name))
+	if n, e := strconv.Atoi(s); e == nil && n > 0 {
+		return syscall.Signal(n), nil
+	}
+	if !strings.HasPrefix(s, "SIG") {
+		s = "SIG" + s
+	}
+	if sig := signalNum(s); sig != 0 {
+		return sig, nil
+	}
+	return 0, fmt.Errorf("Unknown signal %q", name)
+}
+
+// SignalName returns the name of the signal, such as "SIGTERM", or its
+// number if it has no name.
+func SignalName(sig syscall.Signal) string {
+	if name := signalName(sig); name != "" {
+		return name
+	}
+	return strconv.Itoa(int(sig))
+}
+
+// parseStopSteps resolves the signals of a stop sequence.
+func parseStopSteps(steps []StopStep) ([]stopStep, error) {
+	rv := make([]stopStep, 0, len(steps))
+	for i, s := range steps {
+		sig, e := ParseSignal(s.Signal)
+		if e != nil {
+			return nil, e
+		}
+		if s.Wait <= 0 && i != len(steps)-1 {
+			return nil, fmt.Errorf("Stop step %s needs a wait", s.Signal)
+		}
+		rv = append(rv, stopStep{sig: sig, name: SignalName(sig),
+			wait: time.Duration(s.Wait)})
+	}
+	return rv, nil
+}
+
+// validateStop checks the stop and reload settings of a manifest.
+func validateStop(m ProcessManifest) error {
+	if m.ReloadSignal != "" {
+		if len(m.ReloadCmd) != 0 {
+			return fmt.Errorf("reloadSignal may not be used with " +
+				"reloadCommand")
+		}
+		if _, e := ParseSignal(m.ReloadSignal); e != nil {
+			return e
+		}
+	}
+	if len(m.StopSignals) != 0 {
+		if len(m.StopCmd) != 0 || m.StopSignal != "" {
+			return fmt.Errorf("stopSignals may not be used with " +
+				"stopCommand or stopSignal")
+		}
+		_, e := parseStopSteps(m.StopSignals)
+		return e
+	}
+	if m.StopSignal != "" {
+		if len(m.StopCmd) != 0 {
+			return fmt.Errorf("stopSignal may not be used with " +
+				"stopCommand")
+		}
+		_, e := ParseSignal(m.StopSignal)
+		return e
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+// Copyright 2016 The Govisor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package govisor
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// serviceState is the administrative state of a service that we persist.
+type serviceState struct {
+	Enabled bool   `json:"enabled"`
+	Masked  bool   `json:"masked,omitempty"`
+	Failed  bool   `json:"failed,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+type managerState struct {
+	Services  map[string]serviceState `json:"services"`
+	Instances map[string][]string     `json:"instances,omitempty"`
+}
+
+// DefaultStateFile returns the default location of the state file, which
+// is under the base directory, in a directory named for the manager.
+func (m *Manager) DefaultStateFile() string {
+	if m.baseDir == "" {
+		return ""
+	}
+	return filepath.Join(m.baseDir, m.name, "state.json")
+}
+
+// StateFile returns the name of the state file, or the empty string if
+// state is not being saved.
+func (m *Manager) StateFile() string {
+	m.lock()
+	defer m.unlock()
+	return m.stateFile
+}
+
+// SetStateFile sets the file used to persist the administrative state of
+// services (enabled, disabled, masked, and faulted), so that it survives
+// restarts.  The state is written whenever it changes, but only once
+// RestoreState has been called, so that the saved state is not lost.  An
+// empty name, the default, disables saving state.
+func (m *Manager) SetStateFile(name string) {
+	m.lock()
+	m.stateFile = name
+	m.stateData = nil
+	m.restoring = true
+	m.unlock()
+}
+
+// RestoreState loads the state file, and applies the saved state to the
+// services that have been added.  Services that were enabled are enabled
+// again, and masked services are masked.  A service that had failed, and
+// was not going to be restarted automatically, remains failed until it is
+// cleared.  Services that have no saved state are enabled if enable is
+// true, and the same is done for services that Reload adds later.  A
+// missing state file is not an error.  If the state file cannot be read,
+// an error is returned, but the services are still enabled as if there
+// were no saved state.
+func (m *Manager) RestoreState(enable bool) error {
+	m.lock()
+	name := m.stateFile
+	m.unlock()
+
+	saved := managerState{}
+	var err error
+	if name != "" {
+		b, e := ioutil.ReadFile(name)
+		if e == nil {
+			if e = json.Unmarshal(b, &saved); e != nil {
+				m.errorf("[%s] Bad state file %s: %v", m.Name(), name, e)
+				saved = managerState{}
+				err = e
+			}
+		} else if !os.IsNotExist(e) {
+			m.errorf("[%s] Failed reading state: %v", m.Name(), e)
+			err = e
+		}
+	}
+
+	m.lock()
+	m.saved = saved.Services
+	m.enableNew = enable
+	for tmpl, insts := range saved.Instances {
+		for _, inst := range insts {
+			if m.dynamic[tmpl] == nil {
+				m.dynamic[tmpl] = make(map[string]bool)
+			}
+			m.dynamic[tmpl][inst] = true
+		}
+	}
+	m.unlock()
+
+	// Create the instances that were saved.
+	if len(saved.Instances) != 0 {
+		m.reloadMx.Lock()
+		if m.svcDir != "" {
+			m.reload(false)
+		}
+		m.reloadMx.Unlock()
+	}
+
+	svcs, _, _ := m.Services()
+	for _, s := range svcs {
+		m.restoreService(s)
+	}
+
+	m.lock()
+	m.restoring = false
+	m.saveState()
+	m.unlock()
+	return err
+}
+
+// restoreService applies any saved state to the service, or enables it
+// if there is none and the manager was told to do so.
+func (m *Manager) restoreService(s *Service) {
+	m.lock()
+	st, ok := m.saved[s.Name()]
+	enable := m.enableNew
+	m.unlock()
+
+	if !ok {
+		if enable {
+			s.Enable()
+		}
+		return
+	}
+	if st.Masked {
+		s.Mask()
+	}
+	if st.Failed {
+		s.restoreFault(st)
+	} else if st.Enabled {
+		s.Enable()
+	}
+}
+
+func (s *Service) restoreFault(st serviceState) {
+	s.mgr.lock()
+	defer s.mgr.unlock()
+
+	s.serial = s.mgr.bumpSerial()
+	s.enabled = st.Enabled
+	s.failed = true
+	s.exhausted = true
+	s.err = errors.New(st.Error)
+	s.reason = "Failed before restart: " + st.Error
+	s.stamp = time.Now()
+	s.warnf("Service %s remains failed: %s", s.Name(), st.Error)
+}
+
+// savedState returns the state of the service that is saved.  Only
+// terminal failures are saved; a service waiting for an automatic restart
+// will be started afresh.  Call with the manager lock held.
+func (s *Service) savedState() serviceState {
+	ss := serviceState{Enabled: s.enabled, Masked: s.masked}
+	if s.failed && (s.exhausted || !s.restart.wants(s.err)) {
+		ss.Failed = true
+		if s.err != nil {
+			ss.Error = s.err.Error()
+		}
+	}
+	return ss
+}
+
+// saveState writes the state file, if the state has changed since it was
+// last written.  Call with lock held.
+func (m *Manager) saveState() {
+	if m.stateFile == "" || m.restoring {
+		return
+	}
+	st := managerState{Services: make(map[string]serviceState)}
+	// Keep entries for services we don't have, perhaps because their
+	// manifest failed to load, so that their state is not lost.
+	for n, ss := range m.saved {
+		st.Services[n] = ss
+	}
+	for s := range m.services {
+		st.Services[s.Name()] = s.savedState()
+	}
+	for tmpl, insts := range m.dynamic {
+		if st.Instances == nil {
+			st.Instances = make(map[string][]string)
+		}
+		for inst := range insts {
+			st.Instances[tmpl] = append(st.Instances[tmpl], inst)
+		}
+		sort.Strings(st.Instances[tmpl])
+	}
+	b, e := json.MarshalIndent(&st, "", "  ")
+	if e != nil || bytes.Equal(b, m.stateData) {
+		return
+	}
+	if e = writeFileAtomic(m.stateFile, b); e != nil {
+		m.errorf("[%s] Failed saving state: %v", m.Name(), e)
+		return
+	}
+	m.stateData = b
+}
+
+func writeFileAtomic(name string, b []byte) error {
+	dir := filepath.Dir(name)
+	if e := os.MkdirAll(dir, 0755); e != nil {
+		return e
+	}
+	f, e := ioutil.TempFile(dir, ".state")
+	if e != nil {
+		return e
+	}
+	if _, e = f.Write(b); e == nil {
+		e = f.Sync()
+	}
+	if e2 := f.Close(); e == nil {
+		e = e2
+	}
+	if e == nil {
+		e = os.Rename(f.Name(), name)
+	}
+	if e != nil {
+		os.Remove(f.Name())
+	}
+	return e
+}
@@ -0,0 +1,270 @@
+// Copyright 2016 The Govisor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package govisor
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Templates.  A manifest whose file name (less the extension) ends in "@",
+// such as "worker@.json", is a template.  Each instance of a template is a
+// separate service, named <template>:<instance>, so that a dependency on
+// the template name is satisfied by any of its instances.  The template
+// name is the manifest name, or if that is empty, the file name up to the
+// "@".  Instances are listed in the manifest, or created with Instantiate.
+//
+// In the description, command, stop, check and reload commands, environment,
+// environment files, directory, log file, dependencies, conflicts, provides
+// and probes of a template, the following are replaced for each instance:
+//
+//	%i	the instance, e.g. "3"
+//	%n	the service name, e.g. "worker:3"
+//	%p	the template name, e.g. "worker"
+//	%%	a single "%"
+
+// loadedTemplate records a template manifest, so that instances can be
+// created later.
+type loadedTemplate struct {
+	file     string
+	name     string
+	manifest ProcessManifest
+}
+
+// templateName returns the name of the template in a manifest file, or
+// the empty string if the file does not hold a template.
+func templateName(fname string, m ProcessManifest) string {
+	base := filepath.Base(fname)
+	base = strings.TrimSuffix(base, filepath.Ext(base))
+	if !strings.HasSuffix(base, "@") {
+		return ""
+	}
+	if m.Name != "" {
+		return m.Name
+	}
+	return strings.TrimSuffix(base, "@")
+}
+
+// ParseInstance splits a name of the form <template>@<instance>.
+func ParseInstance(name string) (string, string, error) {
+	i := strings.LastIndex(name, "@")
+	if i < 1 {
+		return "", "", ErrBadInstance
+	}
+	if e := checkInstance(name[i+1:]); e != nil {
+		return "", "", e
+	}
+	return name[:i], name[i+1:], nil
+}
+
+// checkInstance makes sure that an instance can be used in a service name.
+func checkInstance(inst string) error {
+	if inst == "" {
+		return ErrBadInstance
+	}
+	for _, c := range inst {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case c >= '0' && c <= '9', c == '_', c == '-', c == '.':
+		default:
+			return ErrBadInstance
+		}
+	}
+	return nil
+}
+
+func checkTemplate(name string, m ProcessManifest) error {
+	if strings.Contains(name, ":") {
+		return fmt.Errorf("Template name %s may not have a variant",
+			name)
+	}
+	for _, inst := range m.Instances {
+		if checkInstance(inst) != nil {
+			return fmt.Errorf("%v: %q", ErrBadInstance, inst)
+		}
+	}
+	return nil
+}
+
+// expand replaces the specifiers described above in s.
+func expand(s string, tmpl string, inst string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' || i == len(s)-1 {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'i':
+			b.WriteString(inst)
+		case 'n':
+			b.WriteString(tmpl + ":" + inst)
+		case 'p':
+			b.WriteString(tmpl)
+		case '%':
+			b.WriteByte('%')
+		default:
+			b.WriteByte('%')
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+func expandAll(src []string, tmpl string, inst string) []string {
+	if src == nil {
+		return nil
+	}
+	rv := make([]string, 0, len(src))
+	for _, s := range src {
+		rv = append(rv, expand(s, tmpl, inst))
+	}
+	return rv
+}
+
+// instanceManifest returns the manifest for an instance of a template.
+func instanceManifest(tmpl string, m ProcessManifest, inst string) ProcessManifest {
+	m.Name = tmpl + ":" + inst
+	m.Instances = nil
+	m.Description = expand(m.Description, tmpl, inst)
+	m.Command = expandAll(m.Command, tmpl, inst)
+	m.StopCmd = expandAll(m.StopCmd, tmpl, inst)
+	m.CheckCmd = expandAll(m.CheckCmd, tmpl, inst)
+	m.ReloadCmd = expandAll(m.ReloadCmd, tmpl, inst)
+	m.Env = expandAll(m.Env, tmpl, inst)
+	m.EnvFiles = expandAll(m.EnvFiles, tmpl, inst)
+	m.Directory = expand(m.Directory, tmpl, inst)
+	m.LogFile = expand(m.LogFile, tmpl, inst)
+	m.Depends = expandAll(m.Depends, tmpl, inst)
+	m.Conflicts = expandAll(m.Conflicts, tmpl, inst)
+	m.Provides = expandAll(m.Provides, tmpl, inst)
+	if m.Probes != nil {
+		probes := make([]ProbeManifest, 0, len(m.Probes))
+		for _, pm := range m.Probes {
+			pm.Command = expandAll(pm.Command, tmpl, inst)
+			pm.Address = expand(pm.Address, tmpl, inst)
+			pm.URL = expand(pm.URL, tmpl, inst)
+			probes = append(probes, pm)
+		}
+		m.Probes = probes
+	}
+	return m
+}
+
+// instances returns the instances of a template, both those listed in the
+// manifest and those created by Instantiate, in sorted order.  Call with
+// the lock held.
+func (m *Manager) instances(tmpl string, mf ProcessManifest) []string {
+	seen := make(map[string]bool)
+	for _, inst := range mf.Instances {
+		seen[inst] = true
+	}
+	for inst := range m.dynamic[tmpl] {
+		seen[inst] = true
+	}
+	rv := make([]string, 0, len(seen))
+	for inst := range seen {
+		rv = append(rv, inst)
+	}
+	sort.Strings(rv)
+	return rv
+}
+
+// Instantiate creates an instance of a template, given a name of the form
+// <template>@<instance>.  The new service is named <template>:<instance>,
+// and is enabled if RestoreState would enable it.  The instance is kept
+// across reloads, and is saved with the state, until Uninstantiate is
+// called.
+func (m *Manager) Instantiate(name string) (*Service, error) {
+	tmpl, inst, e := ParseInstance(name)
+	if e != nil {
+		return nil, e
+	}
+	m.reloadMx.Lock()
+	defer m.reloadMx.Unlock()
+
+	lt := m.templates[tmpl]
+	if lt == nil {
+		return nil, ErrNoTemplate
+	}
+	key := instanceKey(lt.file, inst)
+	if m.loaded[key] != nil {
+		return nil, ErrNameExists
+	}
+	svc, e := m.load(key, lt.file, instanceManifest(tmpl, lt.manifest, inst),
+		nil, true)
+	if e != nil {
+		return nil, e
+	}
+	m.lock()
+	if m.dynamic[tmpl] == nil {
+		m.dynamic[tmpl] = make(map[string]bool)
+	}
+	m.dynamic[tmpl][inst] = true
+	m.saveState()
+	m.unlock()
+	m.logf("[%s] Instantiated %s", m.Name(), svc.Name())
+	return svc, nil
+}
+
+// Uninstantiate removes an instance created by Instantiate, disabling and
+// deleting its service.  Instances listed in the template manifest cannot
+// be removed this way.
+func (m *Manager) Uninstantiate(name string) error {
+	tmpl, inst, e := ParseInstance(name)
+	if e != nil {
+		return e
+	}
+	m.reloadMx.Lock()
+	defer m.reloadMx.Unlock()
+
+	m.lock()
+	if !m.dynamic[tmpl][inst] {
+		m.unlock()
+		return ErrNoInstance
+	}
+	delete(m.dynamic[tmpl], inst)
+	if len(m.dynamic[tmpl]) == 0 {
+		delete(m.dynamic, tmpl)
+	}
+	delete(m.saved, tmpl+":"+inst)
+	m.unlock()
+
+	if lt := m.templates[tmpl]; lt != nil {
+		declared := false
+		for _, i := range lt.manifest.Instances {
+			declared = declared || i == inst
+		}
+		if ls := m.loaded[instanceKey(lt.file, inst)]; ls != nil && !declared {
+			m.unload(ls)
+		}
+	}
+	m.lock()
+	m.saveState()
+	m.unlock()
+	m.logf("[%s] Uninstantiated %s:%s", m.Name(), tmpl, inst)
+	return nil
+}
+
+// instanceKey is the key in Manager.loaded for an instance of a template.
+func instanceKey(file string, inst string) string {
+	return file + "@" + inst
+}
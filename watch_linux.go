@@ -0,0 +1,142 @@
+// Copyright 2016 The Govisor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build linux
+
+package govisor
+
+import (
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const watchMask = syscall.IN_CREATE | syscall.IN_CLOSE_WRITE |
+	syscall.IN_MODIFY | syscall.IN_DELETE | syscall.IN_MOVED_FROM |
+	syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+
+// watchDir watches the directory using inotify, until stop is closed.
+func (m *Manager) watchDir(dir string, stop chan struct{}) error {
+	fd, e := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if e != nil {
+		return os.NewSyscallError("inotify_init1", e)
+	}
+	wd, e := syscall.InotifyAddWatch(fd, dir, watchMask)
+	if e != nil {
+		syscall.Close(fd)
+		return os.NewSyscallError("inotify_add_watch", e)
+	}
+	// Being non-blocking, the file uses the runtime poller, so closing
+	// it wakes up the reader.
+	f := os.NewFile(uintptr(fd), "inotify")
+	go func() {
+		<-stop
+		f.Close()
+	}()
+	go m.watchEvents(f, wd, dir, stop)
+	return nil
+}
+
+// watchEvents reads events until the file is closed.  If the directory goes
+// away, perhaps to be replaced by another, then it waits for it to come
+// back, and watches it again.
+func (m *Manager) watchEvents(f *os.File, wd int, dir string,
+	stop chan struct{}) {
+	events := make(chan struct{}, 1)
+	go m.debounce(events)
+	defer close(events)
+	changed := func() {
+		select {
+		case events <- struct{}{}:
+		default:
+		}
+	}
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, e := f.Read(buf)
+		if e != nil {
+			return
+		}
+		// Changes made once we have stopped are not ours to see.
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		seen := false
+		gone := false
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			off += syscall.SizeofInotifyEvent + int(ev.Len)
+			// Events for an earlier watch of the directory may
+			// still arrive after we have watched it again.
+			if int(ev.Wd) != wd {
+				continue
+			}
+			seen = true
+			if ev.Mask&(syscall.IN_DELETE_SELF|syscall.IN_MOVE_SELF|
+				syscall.IN_IGNORED) != 0 {
+				gone = true
+			}
+		}
+		if seen {
+			changed()
+		}
+		if gone {
+			m.warnf("[%s] Services directory %s went away, "+
+				"waiting for it to return", m.Name(), dir)
+			if wd = m.rewatch(f, wd, dir, stop); wd < 0 {
+				return
+			}
+			m.logf("[%s] Watching %s for changes", m.Name(), dir)
+			// It may have changed while we were not watching.
+			changed()
+		}
+	}
+}
+
+// rewatch waits for the directory to exist again, and watches it, returning
+// the new watch descriptor, or -1 if stop is closed first.  The file is
+// only used under Control, so that stop cannot close it underneath us.
+func (m *Manager) rewatch(f *os.File, old int, dir string,
+	stop chan struct{}) int {
+	rc, e := f.SyscallConn()
+	if e != nil {
+		return -1
+	}
+	// The old watch is gone with the directory, or if it was moved, it
+	// follows it, and so must be removed.
+	rc.Control(func(fd uintptr) {
+		syscall.InotifyRmWatch(int(fd), uint32(old))
+	})
+	for {
+		select {
+		case <-stop:
+			return -1
+		case <-time.After(rewatchDelay):
+		}
+		wd := -1
+		e := rc.Control(func(fd uintptr) {
+			wd, _ = syscall.InotifyAddWatch(int(fd), dir, watchMask)
+		})
+		if e != nil {
+			return -1
+		}
+		if wd >= 0 {
+			return wd
+		}
+	}
+}